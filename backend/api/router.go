@@ -1,18 +1,26 @@
 package api
 
 import (
+	"backend/pkg/config"
 	"backend/pkg/handler"
+	"backend/pkg/mail"
+	"backend/pkg/media"
+	"backend/pkg/middleware"
+	"backend/pkg/oidc"
+	"backend/pkg/ratelimit"
 	"backend/pkg/repository"
 	"backend/pkg/ws"
 	"database/sql"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
 // API layer, handlers, and routing
-func Router(mux *mux.Router, db *sql.DB) {
+func Router(mux *mux.Router, db *sql.DB, cfg config.Config) {
 	// User registration requires input in the form like RegistrationData struct at /pkg/model/stucts.go
 	userRepository := repository.NewUserRepository(db)
 	postRepository := repository.NewPostRepository(db)
@@ -22,68 +30,160 @@ func Router(mux *mux.Router, db *sql.DB) {
 	invitationRepository := repository.NewInvitationRepository(db)
 	notificationRepository := repository.NewNotificationRepository(db)
 	eventRepository := repository.NewEventRepository(db)
-	sessionRepository := repository.NewSessionRepository(db)
+	sessionRepository := repository.NewSessionRepository(db, cfg.Session)
 	friendsRepository := repository.NewFriendsRepository(db)
+	loginAttemptRepository := repository.NewLoginAttemptRepository(db)
+	passwordResetRepository := repository.NewPasswordResetRepository(db)
+	emailVerificationRepository := repository.NewEmailVerificationRepository(db)
+	twoFactorRepository := repository.NewTwoFactorRepository(db)
+	oidcRepository := repository.NewOIDCRepository(db)
+	apiTokenRepository := repository.NewAPITokenRepository(db)
+	mediaRepository := repository.NewMediaRepository(db)
+	reactionRepository := repository.NewReactionRepository(db)
+	searchRepository := repository.NewSearchRepository(db)
 	chatRepository := ws.NewChatRepository(db)
 
-	chatHandler := ws.NewChatHandler(chatRepository, sessionRepository)
-	hub := ws.NewHub(chatHandler)
+	// State-changing requests must carry the CSRF token
+	csrfMiddleware := middleware.NewCSRFMiddleware(cfg.Cookie)
+	mux.Use(csrfMiddleware.Protect)
+
+	// Every route on the mux router requires a valid session unless it is marked public
+	authMiddleware := middleware.NewAuthMiddleware(sessionRepository, apiTokenRepository)
+	authMiddleware.Public("/api/users/register", "/api/users/login", "/api/users/logout", "/api/users/check-auth",
+		"/api/users/password/forgot", "/api/users/password/reset", "/api/users/verify-email", "/api/users/login/2fa",
+		"/api/auth/oidc/providers", "/api/auth/oidc/{provider}/login", "/api/auth/oidc/{provider}/callback",
+		"/api/auth/oidc/registration", "/api/auth/oidc/register")
+	mux.Use(authMiddleware.Authenticate)
+
+	// Users who haven't confirmed their email address can't do what UNVERIFIED_RESTRICTIONS lists
+	verificationPolicy := middleware.NewVerificationPolicy(userRepository, cfg.EmailVerification.Restrict)
+	mailer := mail.NewMailer(cfg.Mail)
+
+	chatHandler := ws.NewChatHandler(chatRepository, sessionRepository, apiTokenRepository, verificationPolicy)
+	hub := ws.NewHub(chatHandler, cfg.AllowedOrigins)
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		hub.ServeWs(w, r)
 	})
 
-	userHandler := handler.NewUserHandler(userRepository, sessionRepository, friendsRepository)
+	// Login brute-force protection, see LOGIN_LIMIT_STORE
+	var loginLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.LoginLimit.Store == "sqlite" {
+		loginLimitStore = repository.NewLoginThrottleRepository(db)
+	}
+	loginLimiter := ratelimit.NewLoginLimiter(loginLimitStore, cfg.LoginLimit)
+
+	emailVerificationHandler := handler.NewEmailVerificationHandler(userRepository, emailVerificationRepository, mailer, cfg.FrontendURL, cfg.EmailVerification)
+	// Uploaded images are validated, re-encoded and stored under content-addressed names in MEDIA_STORE
+	mediaStore, err := media.NewStore(cfg.Media.Store, cfg.Media)
+	if err != nil {
+		log.Fatal("Failed to set up the media store: ", err)
+	}
+	mediaService := media.NewService(cfg.Media, mediaStore)
+	mediaUploader := handler.NewMediaUploader(mediaService, mediaRepository, cfg.Media.MaxAttachments)
+	userHandler := handler.NewUserHandler(userRepository, sessionRepository, apiTokenRepository, friendsRepository, loginAttemptRepository, twoFactorRepository, loginLimiter, emailVerificationHandler, hub, cfg.Cookie, cfg.TwoFactor, cfg.PasswordPolicy, mediaUploader)
 	mux.HandleFunc("/api/users/register", userHandler.UserRegisterHandler).Methods("POST")
 	// User login and logout
-	mux.HandleFunc("/api/users/logout", handler.LogoutHandler).Methods("POST")
+	mux.HandleFunc("/api/users/logout", userHandler.LogoutHandler).Methods("POST")
 	mux.HandleFunc("/api/users/login", userHandler.LoginHandler).Methods("POST")
+	mux.HandleFunc("/api/users/login/2fa", userHandler.LoginTwoFactorHandler).Methods("POST") // Second login step of users with 2FA
 	mux.HandleFunc("/api/users/check-auth", userHandler.CheckAuth)
 	mux.HandleFunc("/api/users/list", userHandler.ListUsersHandler).Methods("GET")
+	// Active sessions (devices) of the logged in user
+	mux.HandleFunc("/api/users/sessions", userHandler.GetSessionsHandler).Methods("GET")
+	mux.HandleFunc("/api/users/sessions", userHandler.RevokeOtherSessionsHandler).Methods("DELETE")
+	mux.HandleFunc("/api/users/sessions/{id}", userHandler.RevokeSessionHandler).Methods("DELETE")
+	// Two-factor authentication (TOTP)
+	twoFactorHandler := handler.NewTwoFactorHandler(userRepository, twoFactorRepository, loginLimiter, cfg.TwoFactor.Issuer)
+	mux.HandleFunc("/api/users/2fa", twoFactorHandler.GetTwoFactorStatusHandler).Methods("GET")
+	mux.HandleFunc("/api/users/2fa/setup", twoFactorHandler.SetupTwoFactorHandler).Methods("POST")
+	mux.HandleFunc("/api/users/2fa/enable", twoFactorHandler.EnableTwoFactorHandler).Methods("POST")
+	mux.HandleFunc("/api/users/2fa/disable", twoFactorHandler.DisableTwoFactorHandler).Methods("POST")
+	// Email verification
+	mux.HandleFunc("/api/users/verify-email", emailVerificationHandler.VerifyEmailHandler).Methods("POST")
+	mux.HandleFunc("/api/users/verify-email/resend", emailVerificationHandler.ResendVerificationHandler).Methods("POST")
+	// Password reset by email, see MAIL_DRIVER
+	passwordResetHandler := handler.NewPasswordResetHandler(userRepository, passwordResetRepository, sessionRepository, apiTokenRepository, loginLimiter, hub, mailer, cfg.FrontendURL, cfg.PasswordResetTTL, cfg.PasswordResetResendInterval, cfg.PasswordPolicy)
+	mux.HandleFunc("/api/users/password/forgot", passwordResetHandler.ForgotPasswordHandler).Methods("POST")
+	mux.HandleFunc("/api/users/password/reset", passwordResetHandler.ResetPasswordHandler).Methods("POST")
+	// Login with external OpenID Connect providers, see OIDC_PROVIDERS
+	oidcProviders := []*oidc.Provider{}
+	for _, providerConfig := range cfg.OIDC.Providers {
+		redirectURL := cfg.BackendURL + "/api/auth/oidc/" + providerConfig.Name + "/callback"
+		oidcProviders = append(oidcProviders, oidc.NewProvider(providerConfig, redirectURL))
+	}
+	oidcHandler := handler.NewOIDCHandler(oidcRepository, userHandler, oidcProviders, cfg.FrontendURL, cfg.OIDC.FlowTTL, cfg.Cookie)
+	mux.HandleFunc("/api/auth/oidc/providers", oidcHandler.GetProvidersHandler).Methods("GET")
+	mux.HandleFunc("/api/auth/oidc/{provider}/login", oidcHandler.LoginHandler).Methods("GET")
+	mux.HandleFunc("/api/auth/oidc/{provider}/link", oidcHandler.LinkHandler).Methods("GET") // Link the provider to the logged in user
+	mux.HandleFunc("/api/auth/oidc/{provider}/callback", oidcHandler.CallbackHandler).Methods("GET")
+	mux.HandleFunc("/api/auth/oidc/registration", oidcHandler.GetRegistrationHandler).Methods("GET") // Prefilled data of a first login
+	mux.HandleFunc("/api/auth/oidc/register", oidcHandler.RegisterHandler).Methods("POST")
+	mux.HandleFunc("/api/users/identities", oidcHandler.GetIdentitiesHandler).Methods("GET")
+	mux.HandleFunc("/api/users/identities/{id}", oidcHandler.UnlinkIdentityHandler).Methods("DELETE")
+	// Personal API tokens for scripts and bots, sent as "Authorization: Bearer <token>"
+	apiTokenHandler := handler.NewAPITokenHandler(apiTokenRepository, hub, cfg.APIToken)
+	mux.HandleFunc("/api/users/tokens", apiTokenHandler.GetAPITokensHandler).Methods("GET")
+	mux.HandleFunc("/api/users/tokens", apiTokenHandler.CreateAPITokenHandler).Methods("POST")
+	mux.HandleFunc("/api/users/tokens/{id}", apiTokenHandler.RevokeAPITokenHandler).Methods("DELETE")
 
 	// Posts
-	postHandler := handler.NewPostHandler(postRepository, sessionRepository, friendsRepository, groupMemberRepository)
+	// Who can see a post (and its comments and images) is decided by visibility
+	visibility := handler.NewVisibility(friendsRepository, groupMemberRepository, postRepository)
+	postHandler := handler.NewPostHandler(postRepository, friendsRepository, groupMemberRepository, commentRepository, reactionRepository, mediaUploader, visibility)
 	mux.HandleFunc("/post", postHandler.GetAllPostsHandler).Methods("GET") // Main feed, all public posts + user groups posts
-	mux.HandleFunc("/post", postHandler.CreatePostHandler).Methods("POST")
-	// mux.HandleFunc("/post/{id}", handler.GetPostByIDHandler).Methods("GET")
-	mux.HandleFunc("/post/{id}", postHandler.EditPostHandler).Methods("PUT")      // Edit a post
+	mux.HandleFunc("/post", verificationPolicy.Require("post", postHandler.CreatePostHandler)).Methods("POST")
+	mux.HandleFunc("/post/{id}", postHandler.GetPostByIDHandler).Methods("GET")
 	mux.HandleFunc("/post/{id}", postHandler.DeletePostHandler).Methods("DELETE") // Delete a post
+	// Edit a post
+	mux.HandleFunc("/post/{id}", verificationPolicy.Require("post", postHandler.EditPostHandler)).Methods("PUT")
+	mux.HandleFunc("/post/{id}/revisions", postHandler.GetPostRevisionsHandler).Methods("GET")
+	mux.HandleFunc("/post/{id}/revisions/diff", postHandler.GetPostDiffHandler).Methods("GET")
 	mux.HandleFunc("/groups/posts/{id}", postHandler.GetPostsByGroupIDHandler).Methods("GET")
 
 	// Profile
 	mux.HandleFunc("/profile/users/{id}", userHandler.GetUserProfileByIDHandler).Methods("GET")
-	mux.HandleFunc("/profile/users/{id}", userHandler.EditUserProfileHandler).Methods("PUT")
+	mux.HandleFunc("/profile/users/{id}", userHandler.EditUserProfileHandler).Methods("PATCH", "PUT") // Partial update, PUT kept for older clients
+	mux.HandleFunc("/api/users/password", userHandler.ChangePasswordHandler).Methods("POST")
 	// Profile feed, all posts by user
 	mux.HandleFunc("/profile/posts/{id}", postHandler.GetAllUserPostsHandler).Methods("GET")
 
 	// Comments
-	commentHandler := handler.NewCommentHandler(commentRepository, sessionRepository)
+	commentHandler := handler.NewCommentHandler(commentRepository, reactionRepository, postRepository, userRepository, notificationRepository, mediaUploader, visibility, cfg.Comments.MaxDepth)
 	mux.HandleFunc("/post/{id}/comments", commentHandler.GetCommentsByUserIDorPostID).Methods("GET")
-	mux.HandleFunc("/post/comment", commentHandler.CreateCommentHandler).Methods("POST")
+	mux.HandleFunc("/post/comment", verificationPolicy.Require("comment", commentHandler.CreateCommentHandler)).Methods("POST")
+	mux.HandleFunc("/post/comment/{id}", verificationPolicy.Require("comment", commentHandler.EditCommentHandler)).Methods("PUT")
 	mux.HandleFunc("/post/comment/{id}", commentHandler.DeleteCommentHandler).Methods("DELETE")
+	mux.HandleFunc("/post/comment/{id}/revisions", commentHandler.GetCommentRevisionsHandler).Methods("GET")
+	mux.HandleFunc("/post/comment/{id}/replies", commentHandler.GetCommentRepliesHandler).Methods("GET")
+
+	// Reactions, sending the same reaction again removes it
+	reactionHandler := handler.NewReactionHandler(reactionRepository, postRepository, commentRepository, userRepository, notificationRepository, visibility)
+	mux.HandleFunc("/post/{id}/reactions", reactionHandler.ReactToPostHandler).Methods("POST")
+	mux.HandleFunc("/post/comment/{id}/reactions", reactionHandler.ReactToCommentHandler).Methods("POST")
 
 	// Groups
-	groupHandler := handler.NewGroupHandler(groupRepository, sessionRepository, groupMemberRepository, notificationRepository)
+	groupHandler := handler.NewGroupHandler(groupRepository, groupMemberRepository, notificationRepository)
 	mux.HandleFunc("/groups", groupHandler.GetAllGroupsHandler).Methods("GET")
-	mux.HandleFunc("/groups", groupHandler.CreateGroupHandler).Methods("POST")
+	mux.HandleFunc("/groups", verificationPolicy.Require("groups", groupHandler.CreateGroupHandler)).Methods("POST")
 	mux.HandleFunc("/groups/{id}", groupHandler.GetGroupByIDHandler).Methods("GET")
 	mux.HandleFunc("/groups/{id}", groupHandler.EditGroupHandler).Methods("PUT")
 	mux.HandleFunc("/groups/{id}", groupHandler.DeleteGroupHandler).Methods("DELETE")
 
 	// Group invitations & requests
-	groupMemberHandler := handler.NewGroupMemberHandler(groupMemberRepository, invitationRepository, sessionRepository, notificationRepository, groupRepository)
+	groupMemberHandler := handler.NewGroupMemberHandler(groupMemberRepository, invitationRepository, notificationRepository, groupRepository)
 	mux.HandleFunc("/invitations", groupMemberHandler.GetAllGroupInvitationsHandler).Methods("GET")
-	mux.HandleFunc("/invitations", groupMemberHandler.InviteGroupMemberHandler).Methods("POST")
+	mux.HandleFunc("/invitations", verificationPolicy.Require("groups", groupMemberHandler.InviteGroupMemberHandler)).Methods("POST")
 	mux.HandleFunc("/invitations/{id}", groupMemberHandler.GetGroupInvitationByIDHandler).Methods("GET")
 	mux.HandleFunc("/invitations/{id}", groupMemberHandler.DeclineGroupInvitationHandler).Methods("PUT")
 	mux.HandleFunc("/invitations/{id}", groupMemberHandler.AcceptGroupInvitationHandler).Methods("PUT")
-	mux.HandleFunc("/invitations/request/{id}", groupMemberHandler.RequestGroupMembershipHandler).Methods("POST")
+	mux.HandleFunc("/invitations/request/{id}", verificationPolicy.Require("groups", groupMemberHandler.RequestGroupMembershipHandler)).Methods("POST")
 	mux.HandleFunc("/groups/{groupId}/members/{userId}", groupMemberHandler.RemoveMemberHandler).Methods("DELETE")
 	mux.HandleFunc("/invitations/approve/{id}", groupMemberHandler.ApproveGroupMembershipHandler).Methods("PUT")
 
 	// Events
-	eventHandler := handler.NewEventHandler(eventRepository, sessionRepository, groupMemberRepository)
+	eventHandler := handler.NewEventHandler(eventRepository, groupMemberRepository)
 	mux.HandleFunc("/events", eventHandler.GetAllEventsHandler).Methods("GET")
-	mux.HandleFunc("/events", eventHandler.CreateEventHandler).Methods("POST")
+	mux.HandleFunc("/events", verificationPolicy.Require("events", eventHandler.CreateEventHandler)).Methods("POST")
 	mux.HandleFunc("/events/{id}", eventHandler.GetEventByIDHandler).Methods("GET")
 	mux.HandleFunc("/events/{id}", eventHandler.EditEventHandler).Methods("PUT")
 	mux.HandleFunc("/events/{id}", eventHandler.DeleteEventHandler).Methods("DELETE")
@@ -92,14 +192,14 @@ func Router(mux *mux.Router, db *sql.DB) {
 	mux.HandleFunc("/events/{id}", eventHandler.GetAttendanceByEventIDHandler).Methods("GET")
 
 	// Notifications
-	notificationHandler := handler.NewNotificationHandler(notificationRepository, sessionRepository)
+	notificationHandler := handler.NewNotificationHandler(notificationRepository)
 	mux.HandleFunc("/notifications", notificationHandler.GetAllNotificationsHandler).Methods("GET")
 	mux.HandleFunc("/notifications", notificationHandler.CreateNotificationHandler).Methods("POST")
 	mux.HandleFunc("/notifications/{id}", notificationHandler.GetNotificationByIDHandler).Methods("GET")
 	mux.HandleFunc("/notifications/{id}", notificationHandler.MarkNotificationAsReadHandler).Methods("PUT")
 
 	// Friends
-	friendHandler := handler.NewFriendHandler(friendsRepository, sessionRepository)
+	friendHandler := handler.NewFriendHandler(friendsRepository)
 	mux.HandleFunc("/friends/request/{id}", friendHandler.SendFriendRequestHandler).Methods("POST")
 	mux.HandleFunc("/friends/accept/{id}", friendHandler.AcceptFriendRequestHandler).Methods("POST")
 	mux.HandleFunc("/friends/decline", friendHandler.DeclineFriendRequestHandler).Methods("POST")
@@ -109,18 +209,39 @@ func Router(mux *mux.Router, db *sql.DB) {
 
 	mux.HandleFunc("/friends", friendHandler.GetFriendsHandler).Methods("GET")
 
-	// route to serve images
-	http.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
-		http.StripPrefix("/images/", http.FileServer(http.Dir("./pkg/db/images"))).ServeHTTP(w, r)
-	})
+	// Search
+	searchHandler := handler.NewSearchHandler(searchRepository)
+	mux.HandleFunc("/search", searchHandler.GetSearchResultsHandler).Methods("GET")
+
+	// Images, served to whoever can see the post, comment or profile they belong to
+	mediaHandler := handler.NewMediaHandler(mediaService, mediaRepository, postRepository, commentRepository, userRepository, visibility)
+	mux.HandleFunc("/images/{name}", mediaHandler.ServeImageHandler).Methods("GET", "HEAD")
+	authMiddleware.Optional("/images/{name}")
+
+	// What API tokens can do: GET requests need the "read" scope, other requests the scope of their route.
+	// Account management can only be done with a session.
+	authMiddleware.Scope("post", "/post", "/post/{id}", "/post/{id}/reactions")
+	authMiddleware.Scope("comment", "/post/comment", "/post/comment/{id}", "/post/comment/{id}/reactions")
+	authMiddleware.Scope("groups", "/groups", "/groups/{id}", "/invitations", "/invitations/{id}", "/invitations/request/{id}",
+		"/groups/{groupId}/members/{userId}", "/invitations/approve/{id}")
+	authMiddleware.Scope("events", "/events", "/events/{id}")
+	authMiddleware.Scope("notifications", "/notifications", "/notifications/{id}")
+	authMiddleware.Scope("friends", "/friends/request/{id}", "/friends/accept/{id}", "/friends/decline", "/friends/block", "/friends/unblock")
+	authMiddleware.SessionOnly("/api/users/tokens", "/api/users/tokens/{id}", "/api/users/password", "/api/users/sessions",
+		"/api/users/sessions/{id}", "/api/users/2fa", "/api/users/2fa/setup", "/api/users/2fa/enable", "/api/users/2fa/disable",
+		"/api/users/verify-email/resend", "/api/users/identities", "/api/users/identities/{id}", "/api/auth/oidc/{provider}/link")
 
 	go hub.Run()
+	// Purge expired sessions from the database
+	go sessionRepository.RunExpiredSessionSweeper(15 * time.Minute)
+	// Forget login failures that are outside the rate limiting window
+	go loginLimiter.RunSweeper(15 * time.Minute)
 	// CORS
 	corsOptions := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000"},                   // Replace with your frontend's origin
-		AllowCredentials: true,                                                // Important for cookies, authorization headers with HTTPS
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},           // You can adjust this based on your needs
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}, // Adjust the methods based on your requirements
+		AllowedOrigins:   cfg.AllowedOrigins,                                           // Frontend origins, see ALLOWED_ORIGINS
+		AllowCredentials: true,                                                         // Important for cookies, authorization headers with HTTPS
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-CSRF-Token"},    // X-CSRF-Token carries the double-submit CSRF token
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, // Adjust the methods based on your requirements
 		// You can include other settings like ExposedHeaders, MaxAge, etc., according to your needs
 	})
 	mux_cors := corsOptions.Handler(mux)
@@ -7,6 +7,7 @@ require (
 	github.com/gorilla/websocket v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.19
 	golang.org/x/crypto v0.14.0
+	golang.org/x/image v0.18.0
 )
 
 require golang.org/x/net v0.17.0 // indirect
@@ -0,0 +1,417 @@
+// Package config loads the backend settings from environment variables.
+// Every setting has a default that works for local development.
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Env is "development" or "production"
+	Env string
+	// AllowedOrigins are the frontend origins allowed to make credentialed requests and open websockets
+	AllowedOrigins []string
+	// FrontendURL is the base URL of the links in emails, e.g. the password reset link
+	FrontendURL string
+	// BackendURL is the public base URL of this server, used for callback URLs and the URLs of uploaded images
+	BackendURL string
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL time.Duration
+	// PasswordResetResendInterval is the shortest time between two password reset emails to the same user
+	PasswordResetResendInterval time.Duration
+	EmailVerification           EmailVerificationConfig
+	TwoFactor                   TwoFactorConfig
+	PasswordPolicy              PasswordPolicyConfig
+	Session                     SessionConfig
+	Cookie                      CookieConfig
+	LoginLimit                  LoginLimitConfig
+	Mail                        MailConfig
+	OIDC                        OIDCConfig
+	APIToken                    APITokenConfig
+	Media                       MediaConfig
+	Comments                    CommentsConfig
+}
+
+// IsProduction reports whether the server runs in the production environment.
+func (c Config) IsProduction() bool {
+	return c.Env == "production"
+}
+
+// SessionConfig controls how long login sessions stay valid.
+// A session expires after IdleTimeout without activity, and after AbsoluteTimeout no matter how active it is.
+// Sessions created with "remember me" use the longer RememberMe timeouts.
+type SessionConfig struct {
+	IdleTimeout               time.Duration
+	AbsoluteTimeout           time.Duration
+	RememberMeIdleTimeout     time.Duration
+	RememberMeAbsoluteTimeout time.Duration
+	// RefreshInterval throttles how often activity extends a session, so not every request writes to the database.
+	RefreshInterval time.Duration
+}
+
+// LoginLimitConfig controls the brute-force protection of the login endpoint.
+// Failed logins are counted per account and per client IP. After FreeAttempts failures every further failure
+// blocks the key for BaseDelay, doubling up to MaxDelay. Reaching a lockout threshold blocks it for LockoutDuration.
+// Failures older than Window are forgotten.
+type LoginLimitConfig struct {
+	// Store is "memory" (default) or "sqlite", which keeps the counters across restarts
+	Store                   string
+	FreeAttempts            int
+	BaseDelay               time.Duration
+	MaxDelay                time.Duration
+	AccountLockoutThreshold int
+	IPLockoutThreshold      int
+	LockoutDuration         time.Duration
+	Window                  time.Duration
+}
+
+// EmailVerificationConfig controls the verification of email addresses after registration.
+type EmailVerificationConfig struct {
+	// TokenTTL is how long a verification link stays valid
+	TokenTTL time.Duration
+	// ResendInterval is the shortest time between two verification emails to the same user
+	ResendInterval time.Duration
+	// Restrict lists what unverified users can't do: "post", "comment", "chat", "groups", "events"
+	Restrict []string
+}
+
+// TwoFactorConfig controls TOTP two-factor authentication.
+type TwoFactorConfig struct {
+	// Issuer is the account name shown in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long the user has to enter the code after the password was accepted
+	ChallengeTTL time.Duration
+}
+
+// PasswordPolicyConfig sets the rules new passwords have to follow (registration, reset and change).
+type PasswordPolicyConfig struct {
+	MinLength int
+	// RequireMixed requires at least one letter and one digit or symbol
+	RequireMixed bool
+}
+
+// MailConfig selects and configures the mail sender.
+// Driver "smtp" sends through the SMTP server; "file" (default) writes the messages to FileDir, or to the log if FileDir is empty.
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	FileDir      string
+}
+
+// OIDCConfig configures login with external OpenID Connect identity providers.
+type OIDCConfig struct {
+	// FlowTTL is how long the user has to finish logging in at the provider, and to finish registering afterwards
+	FlowTTL   time.Duration
+	Providers []OIDCProviderConfig
+}
+
+// OIDCProviderConfig configures one identity provider. Name is used in the login and callback URLs.
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// APITokenConfig controls the lifetime of personal API tokens.
+type APITokenConfig struct {
+	// DefaultTTL is used when the token is created without an expiry
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	MaxPerUser int
+}
+
+// MediaConfig controls the processing and storage of uploaded images.
+type MediaConfig struct {
+	// Store is where images are kept: "local" (Dir) or "s3"
+	Store string
+	// Dir is where the local store keeps images, BaseURL is the URL they are served under
+	Dir     string
+	BaseURL string
+	// SigningKey signs the time-limited URLs of the local store, SignedURLTTL is how long they stay valid
+	SigningKey   string
+	SignedURLTTL time.Duration
+	S3           S3Config
+	// MaxBytes limits the size of an upload, MaxDimension the width and height and MaxPixels their product
+	MaxBytes     int64
+	MaxDimension int
+	MaxPixels    int
+	// ThumbnailSizes are the longest edges of the thumbnails generated for every image
+	ThumbnailSizes []int
+	// MaxAttachments is how many images a post or comment can have
+	MaxAttachments int
+}
+
+// CommentsConfig controls comment threads.
+type CommentsConfig struct {
+	// MaxDepth is how deeply replies can be nested; top-level comments have depth 0, so 0 disables replies
+	MaxDepth int
+}
+
+// S3Config is the bucket of the "s3" media store, at AWS or any S3 compatible storage like MinIO.
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle puts the bucket in the path instead of the host name, which MinIO needs
+	PathStyle bool
+	// BaseURL is the public URL of the bucket, e.g. a CDN; the bucket URL if empty
+	BaseURL string
+}
+
+// CookieConfig holds the attributes of the cookies the server sets.
+type CookieConfig struct {
+	// Secure cookies are only sent over HTTPS; enabled in production
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+// NewCookie returns a cookie available on all paths with the configured attributes.
+// httpOnly hides the cookie from JavaScript; only cookies the frontend has to read should leave it false.
+func (c CookieConfig) NewCookie(name, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: httpOnly,
+		SameSite: c.SameSite,
+	}
+}
+
+// Load reads the configuration from the environment.
+func Load() Config {
+	env := getEnv("APP_ENV", "development")
+	backendURL := strings.TrimSuffix(getEnv("BACKEND_URL", "http://localhost:8080"), "/")
+	return Config{
+		Env:                         env,
+		AllowedOrigins:              strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:3000"), ","),
+		FrontendURL:                 strings.TrimSuffix(getEnv("FRONTEND_URL", "http://localhost:3000"), "/"),
+		BackendURL:                  backendURL,
+		PasswordResetTTL:            getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetResendInterval: getEnvDuration("PASSWORD_RESET_RESEND_INTERVAL", 2*time.Minute),
+		Cookie: CookieConfig{
+			Secure:   getEnvBool("COOKIE_SECURE", env == "production"),
+			SameSite: parseSameSite(getEnv("COOKIE_SAMESITE", "lax")),
+			Domain:   getEnv("COOKIE_DOMAIN", ""),
+		},
+		Session: SessionConfig{
+			IdleTimeout:               getEnvDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute),
+			AbsoluteTimeout:           getEnvDuration("SESSION_ABSOLUTE_TIMEOUT", 12*time.Hour),
+			RememberMeIdleTimeout:     getEnvDuration("SESSION_REMEMBER_ME_IDLE_TIMEOUT", 14*24*time.Hour),
+			RememberMeAbsoluteTimeout: getEnvDuration("SESSION_REMEMBER_ME_ABSOLUTE_TIMEOUT", 90*24*time.Hour),
+			RefreshInterval:           getEnvDuration("SESSION_REFRESH_INTERVAL", time.Minute),
+		},
+		EmailVerification: EmailVerificationConfig{
+			TokenTTL:       getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+			ResendInterval: getEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", 2*time.Minute),
+			Restrict:       getEnvList("UNVERIFIED_RESTRICTIONS", "post,comment,chat,groups"),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:       getEnv("TOTP_ISSUER", "IrieSphere"),
+			ChallengeTTL: getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+		},
+		PasswordPolicy: PasswordPolicyConfig{
+			MinLength:    getEnvInt("PASSWORD_MIN_LENGTH", 8),
+			RequireMixed: getEnvBool("PASSWORD_REQUIRE_MIXED", true),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "file"),
+			From:         getEnv("MAIL_FROM", "IrieSphere <no-reply@iriesphere.local>"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			FileDir:      getEnv("MAIL_FILE_DIR", ""),
+		},
+		LoginLimit: LoginLimitConfig{
+			Store:                   getEnv("LOGIN_LIMIT_STORE", "memory"),
+			FreeAttempts:            getEnvInt("LOGIN_FREE_ATTEMPTS", 3),
+			BaseDelay:               getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+			MaxDelay:                getEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+			AccountLockoutThreshold: getEnvInt("LOGIN_ACCOUNT_LOCKOUT_THRESHOLD", 10),
+			IPLockoutThreshold:      getEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 50),
+			LockoutDuration:         getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			Window:                  getEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+		},
+		OIDC: OIDCConfig{
+			FlowTTL:   getEnvDuration("OIDC_FLOW_TTL", 10*time.Minute),
+			Providers: loadOIDCProviders(),
+		},
+		APIToken: APITokenConfig{
+			DefaultTTL: getEnvDuration("API_TOKEN_DEFAULT_TTL", 30*24*time.Hour),
+			MaxTTL:     getEnvDuration("API_TOKEN_MAX_TTL", 365*24*time.Hour),
+			MaxPerUser: getEnvInt("API_TOKEN_MAX_PER_USER", 20),
+		},
+		Media: MediaConfig{
+			Store:        getEnv("MEDIA_STORE", "local"),
+			Dir:          getEnv("MEDIA_DIR", "./pkg/db/images"),
+			BaseURL:      strings.TrimSuffix(getEnv("MEDIA_BASE_URL", backendURL+"/images"), "/"),
+			SigningKey:   getEnv("MEDIA_SIGNING_KEY", ""),
+			SignedURLTTL: getEnvDuration("MEDIA_SIGNED_URL_TTL", time.Hour),
+			S3: S3Config{
+				Endpoint:        getEnv("MEDIA_S3_ENDPOINT", "https://s3.amazonaws.com"),
+				Region:          getEnv("MEDIA_S3_REGION", "us-east-1"),
+				Bucket:          getEnv("MEDIA_S3_BUCKET", ""),
+				AccessKeyID:     getEnv("MEDIA_S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("MEDIA_S3_SECRET_ACCESS_KEY", ""),
+				PathStyle:       getEnvBool("MEDIA_S3_PATH_STYLE", false),
+				BaseURL:         getEnv("MEDIA_S3_BASE_URL", ""),
+			},
+			MaxBytes:       int64(getEnvInt("MEDIA_MAX_BYTES", 10<<20)),
+			MaxDimension:   getEnvInt("MEDIA_MAX_DIMENSION", 8192),
+			MaxPixels:      getEnvInt("MEDIA_MAX_PIXELS", 40_000_000),
+			ThumbnailSizes: getEnvIntList("MEDIA_THUMBNAIL_SIZES", "160,480,1080"),
+			MaxAttachments: getEnvInt("MEDIA_MAX_ATTACHMENTS", 10),
+		},
+		Comments: CommentsConfig{
+			MaxDepth: getEnvInt("COMMENT_MAX_DEPTH", 3),
+		},
+	}
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS. Each provider NAME is configured with
+// OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET and optionally OIDC_NAME_SCOPES.
+// Providers without an issuer or client ID are skipped.
+func loadOIDCProviders() []OIDCProviderConfig {
+	providers := []OIDCProviderConfig{}
+	for _, name := range getEnvList("OIDC_PROVIDERS", "none") {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			fmt.Printf("OIDC provider %q needs %sISSUER and %sCLIENT_ID, skipping it\n", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// getEnv returns the environment variable or fallback if it is not set.
+func getEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList splits the comma separated environment variable, or fallback if it is not set.
+// The value "none" gives an empty list.
+func getEnvList(key, fallback string) []string {
+	value := getEnv(key, fallback)
+	if value == "none" {
+		return []string{}
+	}
+	return getListItems(value)
+}
+
+// getListItems splits a comma separated value and drops empty items.
+func getListItems(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvIntList parses the comma separated list of integers in the environment variable.
+// It returns the fallback list if the variable is not set or has an invalid item.
+func getEnvIntList(key, fallback string) []int {
+	parse := func(value string) ([]int, error) {
+		list := []int{}
+		for _, item := range getListItems(value) {
+			parsed, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, parsed)
+		}
+		return list, nil
+	}
+	list, err := parse(getEnv(key, fallback))
+	if err != nil {
+		fmt.Printf("Invalid integer list for %s, using default %s\n", key, fallback)
+		list, _ = parse(fallback)
+	}
+	return list
+}
+
+// getEnvBool parses the environment variable with strconv.ParseBool.
+// It returns fallback if the variable is not set or invalid.
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Invalid boolean %q for %s, using default %t\n", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvInt parses the environment variable with strconv.Atoi.
+// It returns fallback if the variable is not set or invalid.
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Invalid integer %q for %s, using default %d\n", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// getEnvDuration parses the environment variable with time.ParseDuration (e.g. "30m", "720h").
+// It returns fallback if the variable is not set or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Invalid duration %q for %s, using default %s\n", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
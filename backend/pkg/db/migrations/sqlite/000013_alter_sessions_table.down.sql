@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sessions_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sessionToken TEXT UNIQUE NOT NULL,
+    userID INTEGER UNIQUE NOT NULL,
+    expiresAt TIMESTAMP,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Only the most recent session of every user can be kept
+INSERT INTO sessions_old (id, sessionToken, userID, expiresAt)
+SELECT id, sessionToken, userID, expiresAt FROM sessions
+WHERE id IN (SELECT MAX(id) FROM sessions GROUP BY userID);
+
+DROP TABLE sessions;
+
+ALTER TABLE sessions_old RENAME TO sessions;
@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN rememberMe;
+ALTER TABLE sessions DROP COLUMN absoluteExpiresAt;
@@ -0,0 +1,4 @@
+ALTER TABLE sessions ADD COLUMN absoluteExpiresAt TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN rememberMe BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE sessions SET absoluteExpiresAt = expiresAt;
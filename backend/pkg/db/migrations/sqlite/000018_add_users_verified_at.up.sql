@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN verified_at TIMESTAMP;
+
+-- Accounts created before email verification existed count as verified
+UPDATE users SET verified_at = CURRENT_TIMESTAMP;
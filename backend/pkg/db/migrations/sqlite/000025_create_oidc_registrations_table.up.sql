@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS oidc_registrations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    username TEXT,
+    first_name TEXT,
+    last_name TEXT,
+    date_of_birth TEXT,
+    avatar_url TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
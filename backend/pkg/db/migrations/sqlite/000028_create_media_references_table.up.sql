@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS media_references (
+    media_id INTEGER NOT NULL,
+    ref_type TEXT NOT NULL,
+    ref_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(media_id, ref_type, ref_id),
+    FOREIGN KEY(media_id) REFERENCES media(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_references_ref ON media_references(ref_type, ref_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS reactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    target_type TEXT NOT NULL CHECK(target_type IN ('post', 'comment')),
+    target_id INTEGER NOT NULL,
+    reaction TEXT NOT NULL CHECK(reaction IN ('like', 'love', 'haha', 'wow', 'sad', 'angry')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, target_type, target_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions(target_type, target_id);
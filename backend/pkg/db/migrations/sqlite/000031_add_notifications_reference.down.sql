@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_notifications_ref;
+ALTER TABLE notifications DROP COLUMN ref_id;
+ALTER TABLE notifications DROP COLUMN ref_type;
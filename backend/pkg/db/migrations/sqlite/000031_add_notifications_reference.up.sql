@@ -0,0 +1,5 @@
+-- What a notification is about, e.g. the post that was reacted to, so notifications about the same thing can be merged
+ALTER TABLE notifications ADD COLUMN ref_type TEXT;
+ALTER TABLE notifications ADD COLUMN ref_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_ref ON notifications(user_id, type, ref_type, ref_id);
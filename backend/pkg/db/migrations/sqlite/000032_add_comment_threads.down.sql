@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_comments_thread;
+ALTER TABLE comments DROP COLUMN deleted_at;
+ALTER TABLE comments DROP COLUMN depth;
+ALTER TABLE comments DROP COLUMN parent_id;
@@ -0,0 +1,7 @@
+-- Replies point at the comment they answer; top-level comments have no parent and depth 0
+ALTER TABLE comments ADD COLUMN parent_id INTEGER;
+ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+-- Deleted comments with replies are kept as "[deleted]" placeholders so their threads stay together
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_comments_thread ON comments(post_id, parent_id);
@@ -0,0 +1,13 @@
+ALTER TABLE comments ADD COLUMN edited_at TIMESTAMP;
+
+-- Every edit keeps the content it replaced, with the time that content was written
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comment_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(comment_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment ON comment_revisions(comment_id);
@@ -0,0 +1,17 @@
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMP;
+
+-- Every edit keeps the version of the post it replaced. version counts from 1, the post as first published;
+-- created_at is when that version was written
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT,
+    image_url TEXT,
+    privacy_setting TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(post_id, version),
+    FOREIGN KEY(post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
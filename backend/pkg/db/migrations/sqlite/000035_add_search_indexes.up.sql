@@ -0,0 +1,86 @@
+-- Full-text search indexes (FTS5, see /search). They are external content tables: the text stays in the
+-- indexed table and the triggers keep the index in sync with it. "rebuild" indexes the existing rows.
+
+-- Usernames and names
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    username, first_name, last_name,
+    content='users', content_rowid='id', tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts(rowid, username, first_name, last_name) VALUES (new.id, new.username, new.first_name, new.last_name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts(users_fts, rowid, username, first_name, last_name) VALUES ('delete', old.id, old.username, old.first_name, old.last_name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF username, first_name, last_name ON users BEGIN
+    INSERT INTO users_fts(users_fts, rowid, username, first_name, last_name) VALUES ('delete', old.id, old.username, old.first_name, old.last_name);
+    INSERT INTO users_fts(rowid, username, first_name, last_name) VALUES (new.id, new.username, new.first_name, new.last_name);
+END;
+
+INSERT INTO users_fts(users_fts) VALUES ('rebuild');
+
+-- Post titles and content
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+    title, content,
+    content='posts', content_rowid='id', tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+    INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+    INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+    INSERT INTO posts_fts(posts_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+    INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+INSERT INTO posts_fts(posts_fts) VALUES ('rebuild');
+
+-- Group titles and descriptions
+CREATE VIRTUAL TABLE IF NOT EXISTS groups_fts USING fts5(
+    title, description,
+    content='groups', content_rowid='id', tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_insert AFTER INSERT ON groups BEGIN
+    INSERT INTO groups_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_delete AFTER DELETE ON groups BEGIN
+    INSERT INTO groups_fts(groups_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS groups_fts_update AFTER UPDATE OF title, description ON groups BEGIN
+    INSERT INTO groups_fts(groups_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+    INSERT INTO groups_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+INSERT INTO groups_fts(groups_fts) VALUES ('rebuild');
+
+-- Event titles and locations
+CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(
+    title, location,
+    content='events', content_rowid='id', tokenize='unicode61 remove_diacritics 2', prefix='2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+    INSERT INTO events_fts(rowid, title, location) VALUES (new.id, new.title, new.location);
+END;
+
+CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+    INSERT INTO events_fts(events_fts, rowid, title, location) VALUES ('delete', old.id, old.title, old.location);
+END;
+
+CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE OF title, location ON events BEGIN
+    INSERT INTO events_fts(events_fts, rowid, title, location) VALUES ('delete', old.id, old.title, old.location);
+    INSERT INTO events_fts(rowid, title, location) VALUES (new.id, new.title, new.location);
+END;
+
+INSERT INTO events_fts(events_fts) VALUES ('rebuild');
@@ -37,6 +37,14 @@ func ConnectAndMigrate(dbPath string, migrationsPath string) (*sql.DB, error) {
 
 	fmt.Println("Connected to SQLite database successfully.")
 
+	// Search needs FTS5, which github.com/mattn/go-sqlite3 only includes when built with -tags sqlite_fts5.
+	// Without it every insert into the indexed tables fails in their triggers, so the server can't run at all.
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil || !fts5 {
+		db.Close()
+		return nil, fmt.Errorf("SQLite was built without FTS5, build the server with -tags sqlite_fts5 (go run -tags sqlite_fts5 .)")
+	}
+
 	migrationsURL := createURL(migrationsPath, "file")
 	dbURL := createURL(dbPath, "sqlite")
 
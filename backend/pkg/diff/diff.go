@@ -0,0 +1,103 @@
+// Package diff compares two versions of a text word by word, e.g. to show what an edit of a post changed.
+package diff
+
+import "regexp"
+
+// Operations of an Edit.
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// maxCells limits the memory of the comparison: texts whose changed parts have more words than this when
+// multiplied are shown as replaced entirely instead.
+const maxCells = 4_000_000
+
+// tokens splits a text into words and the whitespace between them, so joining them gives the text back.
+var tokens = regexp.MustCompile(`\s+|\S+`)
+
+// Edit is a run of text that is the same in both versions, or only in the new (insert) or old one (delete).
+type Edit struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Words returns the edits that turn old into new. Joining the equal and delete texts gives old, joining the equal
+// and insert texts gives new. Identical texts give a single equal edit, or none if they are empty.
+func Words(old, new string) []Edit {
+	a := tokens.FindAllString(old, -1)
+	b := tokens.FindAllString(new, -1)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := &builder{edits: []Edit{}}
+	edits.add(Equal, a[:prefix]...)
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(middleA)*len(middleB) > maxCells {
+		edits.add(Delete, middleA...)
+		edits.add(Insert, middleB...)
+	} else {
+		lcs(edits, middleA, middleB)
+	}
+	edits.add(Equal, a[len(a)-suffix:]...)
+	return edits.edits
+}
+
+// lcs adds the edits between a and b, keeping their longest common subsequence of tokens.
+func lcs(edits *builder, a, b []string) {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits.add(Equal, a[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			edits.add(Delete, a[i])
+			i++
+		default:
+			edits.add(Insert, b[j])
+			j++
+		}
+	}
+	edits.add(Delete, a[i:]...)
+	edits.add(Insert, b[j:]...)
+}
+
+// builder merges consecutive tokens with the same operation into one edit.
+type builder struct {
+	edits []Edit
+}
+
+func (b *builder) add(op string, tokens ...string) {
+	for _, token := range tokens {
+		if n := len(b.edits); n > 0 && b.edits[n-1].Op == op {
+			b.edits[n-1].Text += token
+			continue
+		}
+		b.edits = append(b.edits, Edit{Op: op, Text: token})
+	}
+}
@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []Edit
+	}{
+		{"empty", "", "", []Edit{}},
+		{"identical", "hello world", "hello world", []Edit{{Equal, "hello world"}}},
+		{"from empty", "", "hello world", []Edit{{Insert, "hello world"}}},
+		{"to empty", "hello world", "", []Edit{{Delete, "hello world"}}},
+		{"insert", "hello world", "hello big world", []Edit{{Equal, "hello "}, {Insert, "big "}, {Equal, "world"}}},
+		{"delete", "hello big world", "hello world", []Edit{{Equal, "hello "}, {Delete, "big "}, {Equal, "world"}}},
+		{"replace", "a b c", "a x c", []Edit{{Equal, "a "}, {Delete, "b"}, {Insert, "x"}, {Equal, " c"}}},
+		{"append", "hello", "hello world", []Edit{{Equal, "hello"}, {Insert, " world"}}},
+		{"whitespace", "hello world", "hello\nworld", []Edit{{Equal, "hello"}, {Delete, " "}, {Insert, "\n"}, {Equal, "world"}}},
+		// Words are compared whole, not by characters
+		{"changed word", "the cat sat", "the cart sat", []Edit{{Equal, "the "}, {Delete, "cat"}, {Insert, "cart"}, {Equal, " sat"}}},
+		{"moved word", "one two three", "two three one", []Edit{{Delete, "one "}, {Equal, "two three"}, {Insert, " one"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %v, want %v", tt.old, tt.new, got, tt.want)
+			}
+			checkVersions(t, got, tt.old, tt.new)
+		})
+	}
+}
+
+// TestWordsLarge checks that texts too large to compare are shown as replaced, around their common start and end.
+func TestWordsLarge(t *testing.T) {
+	old := "start " + strings.Repeat("a ", 2100) + "end"
+	new := "start " + strings.Repeat("b ", 2100) + "end"
+	got := Words(old, new)
+	want := []Edit{
+		{Equal, "start "},
+		{Delete, strings.Repeat("a ", 2099) + "a"},
+		{Insert, strings.Repeat("b ", 2099) + "b"},
+		{Equal, " end"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words() = %d edits, want a replacement between the common start and end", len(got))
+	}
+	checkVersions(t, got, old, new)
+}
+
+// checkVersions checks that the edits give back both versions of the text.
+func checkVersions(t *testing.T, edits []Edit, old, new string) {
+	t.Helper()
+	var gotOld, gotNew strings.Builder
+	for i, edit := range edits {
+		if edit.Text == "" {
+			t.Errorf("edit %d is empty", i)
+		}
+		if i > 0 && edits[i-1].Op == edit.Op {
+			t.Errorf("edits %d and %d are both %s", i-1, i, edit.Op)
+		}
+		if edit.Op != Insert {
+			gotOld.WriteString(edit.Text)
+		}
+		if edit.Op != Delete {
+			gotNew.WriteString(edit.Text)
+		}
+	}
+	if gotOld.String() != old || gotNew.String() != new {
+		t.Errorf("the edits give %q -> %q, want %q -> %q", gotOld.String(), gotNew.String(), old, new)
+	}
+}
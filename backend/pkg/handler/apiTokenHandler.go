@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"backend/pkg/ws"
+	"backend/util"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to recognize (e.g. by secret scanners).
+const apiTokenPrefix = "isp_"
+
+// APITokenHandler lets users manage their personal API tokens.
+type APITokenHandler struct {
+	apiTokenRepo *repository.APITokenRepository
+	hub          *ws.Hub
+	config       config.APITokenConfig
+}
+
+func NewAPITokenHandler(atRepo *repository.APITokenRepository, hub *ws.Hub, cfg config.APITokenConfig) *APITokenHandler {
+	return &APITokenHandler{apiTokenRepo: atRepo, hub: hub, config: cfg}
+}
+
+// GetAPITokensHandler lists the tokens of the logged in user. The tokens themselves are never shown again after creation.
+func (h *APITokenHandler) GetAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := h.apiTokenRepo.GetTokensByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error getting API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPITokenHandler creates a named token with the requested scopes (see middleware.APITokenScopes).
+// The response is the only time the token is shown; only its hash is stored.
+func (h *APITokenHandler) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	scopes, message := validateScopes(request.Scopes)
+	if message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	ttl := h.config.DefaultTTL
+	if request.ExpiresInDays < 0 {
+		http.Error(w, "Expiry must be a positive number of days", http.StatusBadRequest)
+		return
+	}
+	if request.ExpiresInDays > 0 {
+		ttl = time.Duration(request.ExpiresInDays) * 24 * time.Hour
+	}
+	if ttl > h.config.MaxTTL {
+		http.Error(w, "Tokens can be valid for at most "+strconv.Itoa(int(h.config.MaxTTL.Hours()/24))+" days", http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.apiTokenRepo.CountTokens(userID)
+	if err != nil {
+		http.Error(w, "Error counting API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count >= h.config.MaxPerUser {
+		http.Error(w, "Too many API tokens, delete one first", http.StatusConflict)
+		return
+	}
+
+	rawToken := apiTokenPrefix + util.GenerateSessionToken()
+	token, err := h.apiTokenRepo.CreateToken(util.HashToken(rawToken), model.APIToken{
+		UserID:    userID,
+		Name:      request.Name,
+		Prefix:    rawToken[:len(apiTokenPrefix)+8],
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		http.Error(w, "Error creating API token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     rawToken,
+		"api_token": token,
+	})
+}
+
+// validateScopes checks the requested scopes against middleware.APITokenScopes and removes duplicates.
+// It returns an error message if a scope is unknown or none was requested.
+func validateScopes(requested []string) ([]string, string) {
+	scopes := []string{}
+	seen := make(map[string]bool)
+	for _, scope := range requested {
+		known := false
+		for _, s := range middleware.APITokenScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, "Unknown scope " + strconv.Quote(scope) + ", use one of: " + strings.Join(middleware.APITokenScopes, ", ")
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "At least one scope is required"
+	}
+	return scopes, ""
+}
+
+// RevokeAPITokenHandler deletes the token with the ID from the URL and closes the chat connections opened with it.
+func (h *APITokenHandler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	tokenID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.apiTokenRepo.DeleteUserToken(tokenID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking API token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseAPITokenConnections(tokenID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "API token revoked",
+	})
+}
@@ -1,113 +1,289 @@
 package handler
 
 import (
+	"backend/pkg/media"
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 type CommentHandler struct {
-	commentRepo *repository.CommentRepository
-	sessionRepo *repository.SessionRepository
+	commentRepo      *repository.CommentRepository
+	reactionRepo     *repository.ReactionRepository
+	postRepo         *repository.PostRepository
+	userRepo         *repository.UserRepository
+	notificationRepo *repository.NotificationRepository
+	mediaUploader    *MediaUploader
+	visibility       *Visibility
+	// maxDepth is how deeply replies can be nested, see config.CommentsConfig
+	maxDepth int
 }
 
-func NewCommentHandler(commentRepo *repository.CommentRepository, sessionRepo *repository.SessionRepository) *CommentHandler {
-	return &CommentHandler{commentRepo: commentRepo, sessionRepo: sessionRepo}
+func NewCommentHandler(commentRepo *repository.CommentRepository, reactionRepo *repository.ReactionRepository, postRepo *repository.PostRepository, userRepo *repository.UserRepository, notificationRepo *repository.NotificationRepository, mediaUploader *MediaUploader, visibility *Visibility, maxDepth int) *CommentHandler {
+	return &CommentHandler{commentRepo: commentRepo, reactionRepo: reactionRepo, postRepo: postRepo, userRepo: userRepo, notificationRepo: notificationRepo, mediaUploader: mediaUploader, visibility: visibility, maxDepth: maxDepth}
 }
 
+// CreateCommentHandler accepts JSON, or multipart form data with the images of the comment in the "images" field.
+// Replies have the ID of the comment they reply to in "parent_id"; its author is notified.
+// Users can only comment on posts they can see.
 func (h *CommentHandler) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	// TODO: id may not come from request and will cause error
 	var newComment model.Comment
-	err := json.NewDecoder(r.Body).Decode(&newComment)
+	var images []*media.Image
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // Maximum memory 10MB, larger uploads go to temporary files
+			http.Error(w, "Error parsing form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		newComment.PostID, err = strconv.Atoi(r.FormValue("post_id"))
+		if err != nil {
+			http.Error(w, "Invalid post ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parentID := r.FormValue("parent_id"); parentID != "" {
+			newComment.ParentID, err = strconv.Atoi(parentID)
+			if err != nil {
+				http.Error(w, "Invalid parent comment ID: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		newComment.Content = r.FormValue("content")
+		images, err = h.mediaUploader.processAll(r, "images")
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&newComment)
+		if err != nil {
+			http.Error(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	newComment.UserID = userID
+
+	post, found, err := h.viewablePost(userID, newComment.PostID)
 	if err != nil {
-		http.Error(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Failed to retrieve the post: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	var parent model.Comment
+	newComment.Depth = 0
+	if newComment.ParentID != 0 {
+		parent, err = h.commentRepo.GetCommentByID(newComment.ParentID)
+		if err == sql.ErrNoRows || (err == nil && parent.Deleted) {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to retrieve the parent comment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if parent.PostID != newComment.PostID {
+			http.Error(w, "The parent comment belongs to another post", http.StatusBadRequest)
+			return
+		}
+		if parent.Depth >= h.maxDepth {
+			http.Error(w, fmt.Sprintf("Replies can't be nested more than %d levels deep", h.maxDepth), http.StatusBadRequest)
+			return
+		}
+		newComment.Depth = parent.Depth + 1
+	}
+
+	saved, err := h.mediaUploader.saveAll(images, userID)
 	if err != nil {
-		http.Error(w, "User not authenticated: "+err.Error(), http.StatusUnauthorized)
+		http.Error(w, "Failed to save the images: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	newComment.UserID = userID
 
 	// Insert the comment into the database
 	createdCommentId, err := h.commentRepo.CreateComment(newComment)
 	if err != nil {
+		if err := h.mediaUploader.discard(saved); err != nil {
+			fmt.Println("Error removing unused images: ", err)
+		}
 		http.Error(w, "Failed to create comment: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := h.mediaUploader.link(saved, commentRefType, int(createdCommentId)); err != nil {
+		// The comment is removed again, so retrying doesn't leave a copy without images
+		if err := h.commentRepo.DeleteComment(int(createdCommentId)); err != nil {
+			fmt.Println("Error removing comment without images: ", err)
+		}
+		if err := h.mediaUploader.abandon(saved, commentRefType, int(createdCommentId)); err != nil {
+			fmt.Println("Error removing unused images: ", err)
+		}
+		http.Error(w, "Failed to attach the images: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if newComment.ParentID != 0 && parent.UserID != userID {
+		// The reply is saved either way
+		if err := h.notifyReply(userID, parent); err != nil {
+			fmt.Println("Error notifying about reply: ", err)
+		}
+	}
+	if isRestrictedPost(post) {
+		h.mediaUploader.sign(saved)
+	}
 
 	// Successful response
 	response := map[string]interface{}{
 		"message": "Comment created successfully",
 		"data":    createdCommentId,
+		"images":  saved,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetCommentsByUserIDorPostID returns a page of the top-level comments of the post, see pageParams.
+// Replies are fetched per comment with GetCommentRepliesHandler. Posts the user can't see get a 404.
 func (h *CommentHandler) GetCommentsByUserIDorPostID(w http.ResponseWriter, r *http.Request) {
-	var id string
-
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&id)
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Error decoding id for comment request: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Failed to parse post ID: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	intid, err := strconv.Atoi(id)
+	h.writeCommentPage(w, r, postID, 0)
+}
+
+// GetCommentRepliesHandler returns a page of the direct replies to the comment, see pageParams.
+func (h *CommentHandler) GetCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Error converting id to int: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	comment, err := h.commentRepo.GetCommentByID(commentID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
-	comments, err := h.commentRepo.GetCommentsByID(intid)
 	if err != nil {
-		http.Error(w, "Error retrieving comments: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error retrieving comment: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(comments)
+	// writeCommentPage checks that the user can see the comment's post
+	h.writeCommentPage(w, r, comment.PostID, comment.Id)
 }
 
-func (h *CommentHandler) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse the post ID from the URL
-	vars := mux.Vars(r)
-	commentID, ok := vars["id"]
-	intcommentID, err := strconv.Atoi(commentID)
+// writeCommentPage responds with a page of the post's top-level comments (parentID 0) or of the replies to a comment,
+// if the user can see the post.
+func (h *CommentHandler) writeCommentPage(w http.ResponseWriter, r *http.Request, postID, parentID int) {
+	limit, after, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, _ := middleware.GetUserID(r)
+	post, found, err := h.viewablePost(userID, postID)
 	if err != nil {
-		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error retrieving post: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if !ok {
-		http.Error(w, "Comment ID is missing in parameters", http.StatusBadRequest)
+	if !found {
+		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
-	cookie, err := r.Cookie("session_token")
+	// One extra comment tells whether there is a next page
+	comments, err := h.commentRepo.GetCommentPage(postID, parentID, after, limit+1)
+	if err != nil {
+		http.Error(w, "Error retrieving comments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := model.CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = encodeCursor(page.Comments[limit-1].CreatedAt, page.Comments[limit-1].Id)
+	}
+	if err := h.attachImages(page.Comments); err != nil {
+		http.Error(w, "Error retrieving images: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachCommentReactions(h.reactionRepo, page.Comments, userID); err != nil {
+		http.Error(w, "Error retrieving reactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isRestrictedPost(post) {
+		for _, comment := range page.Comments {
+			h.mediaUploader.sign(comment.Images)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// DeleteCommentHandler deletes a comment on a post the user can see. Comments can be deleted by their author and
+// by the moderators of the post, see Visibility.CanModerateComments.
+func (h *CommentHandler) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the comment ID from the URL
+	intcommentID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Error authenticating user: "+err.Error(), http.StatusUnauthorized)
+		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Confirm user auth and get userid
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(cookie.Value)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
+	comment, post, found, err := h.viewableComment(userID, intcommentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the comment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if comment.UserID != userID {
+		canModerate, err := h.visibility.CanModerateComments(userID, post)
+		if err != nil {
+			http.Error(w, "Failed to check permissions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !canModerate {
+			http.Error(w, "Only the comment author, the post author and group admins can delete this comment", http.StatusForbidden)
+			return
+		}
+	}
+
 	// Delete the comment from the database
-	err = h.commentRepo.DeleteComment(intcommentID, userID)
+	err = h.commentRepo.DeleteComment(intcommentID)
 	if err != nil {
 		http.Error(w, "Failed to delete the comment: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// The comment is gone either way, images that can't be cleaned up now are only orphaned
+	if err := h.mediaUploader.release(commentRefType, intcommentID); err != nil {
+		fmt.Println("Error deleting images of comment: ", err)
+	}
+	if err := h.reactionRepo.DeleteReactions(commentRefType, intcommentID); err != nil {
+		fmt.Println("Error deleting reactions of comment: ", err)
+	}
 
 	// Successful response
 	response := map[string]string{
@@ -117,28 +293,18 @@ func (h *CommentHandler) DeleteCommentHandler(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(response)
 }
 
+// EditCommentHandler changes the content of the user's own comment, on a post the user can still see.
+// The previous content is kept, see GetCommentRevisionsHandler.
 func (h *CommentHandler) EditCommentHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse the post ID from the URL
-	vars := mux.Vars(r)
-	commentID, ok := vars["id"]
-	intcommentID, err := strconv.Atoi(commentID)
+	// Parse the comment ID from the URL
+	intcommentID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if !ok {
-		http.Error(w, "Comment ID is missing in parameters", http.StatusBadRequest)
-		return
-	}
-
-	cookie, err := r.Cookie("session_token")
-	if err != nil {
-		http.Error(w, "Error authenticating user: "+err.Error(), http.StatusUnauthorized)
+		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Confirm user auth and get userid
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(cookie.Value)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
 		return
@@ -151,6 +317,20 @@ func (h *CommentHandler) EditCommentHandler(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Error decoding request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if strings.TrimSpace(commentData.Content) == "" {
+		http.Error(w, "Comment content is required", http.StatusBadRequest)
+		return
+	}
+
+	comment, _, found, err := h.viewableComment(userID, intcommentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the comment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found || comment.UserID != userID {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
 
 	// Update the comment in the database
 	err = h.commentRepo.UpdateComment(intcommentID, userID, commentData)
@@ -166,3 +346,126 @@ func (h *CommentHandler) EditCommentHandler(w http.ResponseWriter, r *http.Reque
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// GetCommentRevisionsHandler returns the previous versions of a comment, newest first. The edit history is for the
+// comment's author and the moderators of the post, see Visibility.CanModerateComments.
+func (h *CommentHandler) GetCommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to parse comment ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	comment, post, found, err := h.viewableComment(userID, commentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the comment: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if comment.UserID != userID {
+		canModerate, err := h.visibility.CanModerateComments(userID, post)
+		if err != nil {
+			http.Error(w, "Failed to check permissions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !canModerate {
+			http.Error(w, "Only the post author and moderators can see the edit history", http.StatusForbidden)
+			return
+		}
+	}
+
+	revisions, err := h.commentRepo.GetCommentRevisions(commentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the edit history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// viewablePost returns the post if it exists and the user can see it; found is false otherwise.
+func (h *CommentHandler) viewablePost(userID, postID int) (model.Post, bool, error) {
+	post, err := h.postRepo.GetPostByID(postID)
+	if err == sql.ErrNoRows {
+		return model.Post{}, false, nil
+	}
+	if err != nil {
+		return model.Post{}, false, err
+	}
+	canView, err := h.visibility.CanViewPost(userID, post)
+	if err != nil || !canView {
+		return model.Post{}, false, err
+	}
+	return post, true, nil
+}
+
+// viewableComment returns the comment and its post if the user can see the post. Deleted comments aren't found.
+func (h *CommentHandler) viewableComment(userID, commentID int) (model.Comment, model.Post, bool, error) {
+	comment, err := h.commentRepo.GetCommentByID(commentID)
+	if err == sql.ErrNoRows || (err == nil && comment.Deleted) {
+		return model.Comment{}, model.Post{}, false, nil
+	}
+	if err != nil {
+		return model.Comment{}, model.Post{}, false, err
+	}
+	post, found, err := h.viewablePost(userID, comment.PostID)
+	if err != nil || !found {
+		return model.Comment{}, model.Post{}, false, err
+	}
+	return comment, post, true, nil
+}
+
+// notifyReply tells the author of the parent comment about the reply. All unread replies to the same comment
+// share one notification.
+func (h *CommentHandler) notifyReply(userID int, parent model.Comment) error {
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	repliers, err := h.commentRepo.CountRepliers(parent.Id, parent.UserID)
+	if err != nil {
+		return err
+	}
+	message := user.Username + " replied to your comment"
+	if repliers > 1 {
+		message = fmt.Sprintf("%s and %d others replied to your comment", user.Username, repliers-1)
+	}
+	return h.notificationRepo.MergeNotification(model.Notification{
+		UserId:  parent.UserID,
+		Type:    "comment_reply",
+		Message: message,
+		RefType: commentRefType,
+		RefID:   parent.Id,
+	})
+}
+
+// attachImages fills in the images of the comments with one query.
+func (h *CommentHandler) attachImages(comments []model.Comment) error {
+	return attachCommentImages(h.mediaUploader, comments)
+}
+
+func attachCommentImages(mediaUploader *MediaUploader, comments []model.Comment) error {
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.Id
+	}
+	images, err := mediaUploader.images(commentRefType, ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Images = images[comments[i].Id]
+		if comments[i].Images == nil {
+			comments[i].Images = []model.Media{}
+		}
+	}
+	return nil
+}
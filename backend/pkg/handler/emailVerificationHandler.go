@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/mail"
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"backend/util"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type EmailVerificationHandler struct {
+	userRepo              *repository.UserRepository
+	emailVerificationRepo *repository.EmailVerificationRepository
+	mailer                mail.Mailer
+	frontendURL           string
+	config                config.EmailVerificationConfig
+}
+
+func NewEmailVerificationHandler(uRepo *repository.UserRepository, evRepo *repository.EmailVerificationRepository, mailer mail.Mailer, frontendURL string, cfg config.EmailVerificationConfig) *EmailVerificationHandler {
+	return &EmailVerificationHandler{userRepo: uRepo, emailVerificationRepo: evRepo, mailer: mailer, frontendURL: frontendURL, config: cfg}
+}
+
+// sendVerification stores a new verification token for the user and emails them the link.
+// The email itself is sent in the background.
+func (h *EmailVerificationHandler) sendVerification(userID int, email, firstName string) error {
+	token := util.GenerateSessionToken()
+	err := h.emailVerificationRepo.CreateToken(userID, util.HashToken(token), time.Now().Add(h.config.TokenTTL))
+	if err != nil {
+		return err
+	}
+
+	link := h.frontendURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+	go func() {
+		err := h.mailer.Send(mail.Message{
+			To:      email,
+			Subject: "Confirm your IrieSphere email address",
+			Body: fmt.Sprintf("Hi %s,\n\nWelcome to IrieSphere! Open the link below to confirm your email address. "+
+				"It is valid for %d hours.\n\n%s\n\nIf you didn't create an account, you can ignore this email.\n",
+				firstName, int(h.config.TokenTTL.Hours()), link),
+		})
+		if err != nil {
+			fmt.Println("Error sending verification email: ", err)
+		}
+	}()
+	return nil
+}
+
+// VerifyEmailHandler confirms the email address with the token from a verification email.
+// It doesn't need a session, so the link also works in a browser the user isn't logged in with.
+func (h *EmailVerificationHandler) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.emailVerificationRepo.ConsumeToken(util.HashToken(request.Token))
+	if err == repository.ErrInvalidVerificationToken {
+		http.Error(w, "Verification link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error verifying email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+}
+
+// ResendVerificationHandler emails a new verification link to the logged in user.
+// Only one email per ResendInterval is sent; earlier requests get a 429 with a Retry-After header.
+func (h *EmailVerificationHandler) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !user.VerifiedAt.IsZero() {
+		http.Error(w, "Email address is already verified", http.StatusConflict)
+		return
+	}
+
+	lastSentAt, err := h.emailVerificationRepo.GetLastTokenCreatedAt(userID)
+	if err != nil {
+		http.Error(w, "Error getting verification token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait := time.Until(lastSentAt.Add(h.config.ResendInterval)); wait > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "A verification email was sent recently. Try again later."})
+		return
+	}
+
+	if err := h.sendVerification(user.Id, user.Email, user.FirstName); err != nil {
+		http.Error(w, "Error creating verification token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
@@ -1,9 +1,9 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -14,11 +14,10 @@ import (
 type EventHandler struct {
 	eventRepo   *repository.EventRepository
 	groupMemberRepo *repository.GroupMemberRepository
-	sessionRepo *repository.SessionRepository
 }
 
-func NewEventHandler(eventRepo *repository.EventRepository, sessionRepo *repository.SessionRepository, groupMemberRepo *repository.GroupMemberRepository) *EventHandler {
-	return &EventHandler{eventRepo: eventRepo, sessionRepo: sessionRepo, groupMemberRepo: groupMemberRepo}
+func NewEventHandler(eventRepo *repository.EventRepository, groupMemberRepo *repository.GroupMemberRepository) *EventHandler {
+	return &EventHandler{eventRepo: eventRepo, groupMemberRepo: groupMemberRepo}
 }
 
 // Event Handlers
@@ -40,9 +39,9 @@ func (h *EventHandler) CreateEventHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	// check if event with title already exists IN FRONTEND
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	isMember, err := h.groupMemberRepo.IsUserGroupMember(userID, newEvent.GroupId)
@@ -93,9 +92,9 @@ func (h *EventHandler) EditEventHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to get group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if event.CreatorId != userID {
@@ -112,9 +111,9 @@ func (h *EventHandler) EditEventHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *EventHandler) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -1,9 +1,9 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -14,12 +14,11 @@ import (
 )
 
 type FriendHandler struct {
-	friendRepository  *repository.FriendsRepository
-	sessionRepository *repository.SessionRepository
+	friendRepository *repository.FriendsRepository
 }
 
-func NewFriendHandler(friendRepository *repository.FriendsRepository, sessionRepository *repository.SessionRepository) *FriendHandler {
-	return &FriendHandler{friendRepository: friendRepository, sessionRepository: sessionRepository}
+func NewFriendHandler(friendRepository *repository.FriendsRepository) *FriendHandler {
+	return &FriendHandler{friendRepository: friendRepository}
 }
 
 // SendFriendRequestHandler handles the HTTP request for sending a friend request.
@@ -28,8 +27,7 @@ func NewFriendHandler(friendRepository *repository.FriendsRepository, sessionRep
 // If a friend request is already pending or the users are already friends or one user has blocked the other, it returns an error.
 // It returns http.StatusCreated if the friend request is sent successfully.
 func (h *FriendHandler) SendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -77,8 +75,7 @@ func (h *FriendHandler) SendFriendRequestHandler(w http.ResponseWriter, r *http.
 // If successful, it updates the friend status to "accepted" and returns a 200 OK response.
 // If any error occurs, it returns an appropriate HTTP error response.
 func (h *FriendHandler) AcceptFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -104,8 +101,7 @@ func (h *FriendHandler) AcceptFriendRequestHandler(w http.ResponseWriter, r *htt
 // If successful, it updates the friend status to "declined" and returns a 200 OK response.
 // If there is an error, it returns an appropriate HTTP error response.
 func (h *FriendHandler) DeclineFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -131,8 +127,7 @@ func (h *FriendHandler) DeclineFriendRequestHandler(w http.ResponseWriter, r *ht
 // If successful, it updates the friend status to "blocked" and returns a status code of 200.
 // If there is an error, it returns an appropriate HTTP error response.
 func (h *FriendHandler) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -158,8 +153,7 @@ func (h *FriendHandler) BlockUserHandler(w http.ResponseWriter, r *http.Request)
 // If successful, it updates the friend status to "accepted" and returns a 200 OK response.
 // If there is an error, it returns an appropriate HTTP error response.
 func (h *FriendHandler) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -186,8 +180,7 @@ func (h *FriendHandler) UnblockUserHandler(w http.ResponseWriter, r *http.Reques
 // If there is an error retrieving the friends, it returns a 500 Internal Server Error.
 // The response is encoded in JSON format.
 func (h *FriendHandler) GetFriendsHandler(w http.ResponseWriter, r *http.Request) {
-	sessionToken := util.GetSessionToken(r)
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(sessionToken)
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -226,7 +219,7 @@ func (h *FriendHandler) GetFriendsHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (h *FriendHandler) CheckFriendStatusHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.sessionRepository.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
@@ -1,9 +1,9 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -15,12 +15,11 @@ import (
 type GroupHandler struct {
 	groupRepo        *repository.GroupRepository
 	groupMemberRepo  *repository.GroupMemberRepository
-	sessionRepo      *repository.SessionRepository
 	notificationRepo *repository.NotificationRepository
 }
 
-func NewGroupHandler(groupRepo *repository.GroupRepository, sessionRepo *repository.SessionRepository, groupMemberRepo *repository.GroupMemberRepository, notificationRepo *repository.NotificationRepository) *GroupHandler {
-	return &GroupHandler{groupRepo: groupRepo, sessionRepo: sessionRepo, groupMemberRepo: groupMemberRepo, notificationRepo: notificationRepo}
+func NewGroupHandler(groupRepo *repository.GroupRepository, groupMemberRepo *repository.GroupMemberRepository, notificationRepo *repository.NotificationRepository) *GroupHandler {
+	return &GroupHandler{groupRepo: groupRepo, groupMemberRepo: groupMemberRepo, notificationRepo: notificationRepo}
 }
 
 // Group Handlers
@@ -44,9 +43,9 @@ func (h *GroupHandler) CreateGroupHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	// TODO: check if group with title already exists IN FRONTEND
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	newGroup.CreatorId = userID
@@ -91,9 +90,9 @@ func (h *GroupHandler) EditGroupHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to get group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if group.CreatorId != userID {
@@ -116,9 +115,9 @@ func (h *GroupHandler) EditGroupHandler(w http.ResponseWriter, r *http.Request)
 // TODO: implement notification to all group members that the group has been deleted, and remove all group members;
 // implement logging of the deletion or add bool field "deleted"
 func (h *GroupHandler) DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
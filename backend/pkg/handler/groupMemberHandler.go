@@ -1,9 +1,9 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -16,13 +16,12 @@ import (
 type GroupMemberHandler struct {
 	groupMemberRepo  *repository.GroupMemberRepository
 	invitationRepo   *repository.InvitationRepository
-	sessionRepo      *repository.SessionRepository
 	notificationRepo *repository.NotificationRepository
 	groupRepo        *repository.GroupRepository
 }
 
-func NewGroupMemberHandler(groupMemberRepo *repository.GroupMemberRepository, invitationRepo *repository.InvitationRepository, sessionRepo *repository.SessionRepository, notificationRepo *repository.NotificationRepository, groupRepo *repository.GroupRepository) *GroupMemberHandler {
-	return &GroupMemberHandler{groupMemberRepo: groupMemberRepo, invitationRepo: invitationRepo, sessionRepo: sessionRepo, notificationRepo: notificationRepo, groupRepo: groupRepo}
+func NewGroupMemberHandler(groupMemberRepo *repository.GroupMemberRepository, invitationRepo *repository.InvitationRepository, notificationRepo *repository.NotificationRepository, groupRepo *repository.GroupRepository) *GroupMemberHandler {
+	return &GroupMemberHandler{groupMemberRepo: groupMemberRepo, invitationRepo: invitationRepo, notificationRepo: notificationRepo, groupRepo: groupRepo}
 }
 
 // RemoveMemberFromGroup removes a user from a group. It takes two parameters: the ID of the group
@@ -52,9 +51,9 @@ func (h *GroupMemberHandler) RemoveMemberHandler(w http.ResponseWriter, r *http.
 		http.Error(w, "Failed to convert userid string to int: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	requestingUserId, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	requestingUserId, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	// logic to check if the user trying to remove the member is the owner of the group
@@ -88,9 +87,9 @@ func (h *GroupMemberHandler) RequestGroupMembershipHandler(w http.ResponseWriter
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	request.JoinUserId = userID
@@ -224,9 +223,9 @@ func (h *GroupMemberHandler) DeclineGroupMembershipHandler(w http.ResponseWriter
 	id := vars["id"]
 
 	// Retrieve the user ID from the session token
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -283,9 +282,9 @@ func (h *GroupMemberHandler) InviteGroupMemberHandler(w http.ResponseWriter, r *
 		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	newInvitation.InviteUserId = userID
@@ -295,13 +294,6 @@ func (h *GroupMemberHandler) InviteGroupMemberHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	groupID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
-	if err != nil {
-		http.Error(w, "Failed to get user id from session token: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	newInvitation.GroupId = groupID
-
 	// Notify the user that they have been invited to join a group
 	err = notifyUserInvitation(h.notificationRepo, newInvitation.InviteUserId, newInvitation.GroupId, "You have been invited to join a group.")
 	if err != nil {
@@ -479,9 +471,9 @@ func notifyInvitationDecline(invitationRepo *repository.InvitationRepository, no
 // GetGroupInvitationByIDHandler gets an invitation by ID for the user.
 func (h *GroupMemberHandler) GetGroupInvitationByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the user ID from the cookie.
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error extracting user ID from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error extracting user ID from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -507,9 +499,9 @@ func (h *GroupMemberHandler) GetGroupInvitationByIDHandler(w http.ResponseWriter
 // GetAllGroupInvitationsHandler gets all pending invitations for the user.
 func (h *GroupMemberHandler) GetAllGroupInvitationsHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the user ID from the cookie.
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error extracting user ID from session token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error extracting user ID from session token: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"backend/pkg/media"
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"database/sql"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MediaHandler serves uploaded images to the users who can see what they belong to.
+type MediaHandler struct {
+	mediaService *media.Service
+	mediaRepo    *repository.MediaRepository
+	postRepo     *repository.PostRepository
+	commentRepo  *repository.CommentRepository
+	userRepo     *repository.UserRepository
+	visibility   *Visibility
+}
+
+func NewMediaHandler(mediaService *media.Service, mRepo *repository.MediaRepository, pRepo *repository.PostRepository, cRepo *repository.CommentRepository, uRepo *repository.UserRepository, visibility *Visibility) *MediaHandler {
+	return &MediaHandler{mediaService: mediaService, mediaRepo: mRepo, postRepo: pRepo, commentRepo: cRepo, userRepo: uRepo, visibility: visibility}
+}
+
+// ServeImageHandler serves an image or thumbnail by file name. A valid signed URL is enough to get the file;
+// otherwise the logged in (or anonymous) user must be able to see a post, comment or profile that uses it.
+// Images the user can't see get a 404, so their existence isn't revealed.
+func (h *MediaHandler) ServeImageHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if h.mediaService.VerifySignedURL(name, r.URL.Query()) {
+		h.mediaService.Serve(w, r, name, false)
+		return
+	}
+
+	viewerID, _ := middleware.GetUserID(r) // 0 for anonymous users
+	canView, public, err := h.canViewImage(viewerID, name)
+	if err != nil {
+		http.Error(w, "Error checking image access: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !canView {
+		http.NotFound(w, r)
+		return
+	}
+	h.mediaService.Serve(w, r, name, public)
+}
+
+// canViewImage reports whether the viewer can see the image, and whether everyone can (so it can be cached publicly).
+// The same file can be used several times, e.g. one photo posted twice; seeing one of its uses is enough.
+func (h *MediaHandler) canViewImage(viewerID int, name string) (bool, bool, error) {
+	hash, ok := media.HashFromFileName(name)
+	if !ok {
+		userIDs, err := h.mediaRepo.GetLegacyAvatarOwners(name)
+		if err != nil {
+			return false, false, err
+		}
+		references := []model.MediaReference{}
+		for _, userID := range userIDs {
+			references = append(references, model.MediaReference{OwnerID: userID, RefType: avatarRefType, RefID: userID})
+		}
+		return h.canViewReferences(viewerID, references)
+	}
+	references, err := h.mediaRepo.GetReferencesByHash(hash)
+	if err != nil {
+		return false, false, err
+	}
+	return h.canViewReferences(viewerID, references)
+}
+
+func (h *MediaHandler) canViewReferences(viewerID int, references []model.MediaReference) (bool, bool, error) {
+	canView := false
+	for _, reference := range references {
+		anonymous, err := h.canViewReference(0, reference)
+		if err != nil {
+			return false, false, err
+		}
+		if anonymous {
+			return true, true, nil
+		}
+		if !canView && viewerID != 0 {
+			canView, err = h.canViewReference(viewerID, reference)
+			if err != nil {
+				return false, false, err
+			}
+		}
+	}
+	return canView, false, nil
+}
+
+// canViewReference checks one use of an image. Images that nothing uses yet are only for their owner.
+func (h *MediaHandler) canViewReference(viewerID int, reference model.MediaReference) (bool, error) {
+	switch reference.RefType {
+	case avatarRefType:
+		user, err := h.userRepo.GetUserByID(reference.RefID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return h.visibility.CanViewProfile(viewerID, user)
+	case postRefType:
+		return h.canViewPost(viewerID, reference.RefID)
+	case commentRefType:
+		comment, err := h.commentRepo.GetCommentByID(reference.RefID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return h.canViewPost(viewerID, comment.PostID)
+	default:
+		return viewerID != 0 && viewerID == reference.OwnerID, nil
+	}
+}
+
+func (h *MediaHandler) canViewPost(viewerID, postID int) (bool, error) {
+	post, err := h.postRepo.GetPostByID(postID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return h.visibility.CanViewPost(viewerID, post)
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"backend/pkg/media"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Reference types of media_references, the ref_id is the ID of the user, post or comment.
+const (
+	avatarRefType  = "user_avatar"
+	postRefType    = "post"
+	commentRefType = "comment"
+)
+
+// errTooManyImages is returned by processAll when more files than maxAttachments are uploaded.
+var errTooManyImages = errors.New("too many images")
+
+// MediaUploader stores images uploaded with multipart forms through the media service and records them in the media table.
+type MediaUploader struct {
+	service   *media.Service
+	mediaRepo *repository.MediaRepository
+	// maxAttachments limits how many images a post or comment can have
+	maxAttachments int
+}
+
+func NewMediaUploader(service *media.Service, mRepo *repository.MediaRepository, maxAttachments int) *MediaUploader {
+	return &MediaUploader{service: service, mediaRepo: mRepo, maxAttachments: maxAttachments}
+}
+
+// process validates and re-encodes the image in the form field. It returns nil if no file was uploaded.
+// The request must already have been parsed with ParseMultipartForm.
+func (u *MediaUploader) process(r *http.Request, field string) (*media.Image, error) {
+	file, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return u.service.Process(file)
+}
+
+// processAll validates and re-encodes every file uploaded in the form field, e.g. the images of a post.
+// All images are checked before anything is saved, so one bad file rejects the whole request.
+func (u *MediaUploader) processAll(r *http.Request, field string) ([]*media.Image, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	headers := r.MultipartForm.File[field]
+	if len(headers) > u.maxAttachments {
+		return nil, fmt.Errorf("%w, at most %d can be attached", errTooManyImages, u.maxAttachments)
+	}
+	images := []*media.Image{}
+	for i, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		img, err := u.service.Process(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("image %d (%s): %w", i+1, header.Filename, err)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// saveAll saves the images like save. They are referenced with link once the post or comment exists, or
+// discarded if it can't be created. If an image can't be saved, the ones saved before it are discarded.
+func (u *MediaUploader) saveAll(images []*media.Image, ownerID int) ([]model.Media, error) {
+	saved := []model.Media{}
+	for _, img := range images {
+		m, err := u.save(img, ownerID)
+		if err != nil {
+			if err := u.discard(saved); err != nil {
+				fmt.Println("Error removing unused images: ", err)
+			}
+			return nil, err
+		}
+		saved = append(saved, m)
+	}
+	return saved, nil
+}
+
+// link references the media from e.g. a post.
+func (u *MediaUploader) link(media []model.Media, refType string, refID int) error {
+	for _, m := range media {
+		if err := u.mediaRepo.AddReference(m.Id, refType, refID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release removes the references of e.g. a deleted post. Media that nothing references anymore is deleted,
+// and so are its files unless an identical upload still uses them.
+func (u *MediaUploader) release(refType string, refID int) error {
+	media, err := u.mediaRepo.GetMediaByReference(refType, refID)
+	if err != nil {
+		return err
+	}
+	if err := u.mediaRepo.RemoveReferences(refType, refID); err != nil {
+		return err
+	}
+	return u.discard(media)
+}
+
+// discard deletes media that nothing references, e.g. images saved for an update that then failed,
+// and its files unless an identical upload still uses them.
+func (u *MediaUploader) discard(media []model.Media) error {
+	for _, m := range media {
+		deleted, err := u.mediaRepo.DeleteUnreferencedMedia(m.Id)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+		count, err := u.mediaRepo.CountMediaByHash(m.Hash)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := u.service.Delete(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// abandon removes media that was saved and partly linked for e.g. a post that then couldn't be completed:
+// the references to refID and then the media itself, like discard.
+func (u *MediaUploader) abandon(media []model.Media, refType string, refID int) error {
+	if err := u.mediaRepo.RemoveReferences(refType, refID); err != nil {
+		return err
+	}
+	return u.discard(media)
+}
+
+// images returns the media of e.g. posts by their ID, with URLs.
+func (u *MediaUploader) images(refType string, refIDs []int) (map[int][]model.Media, error) {
+	media, err := u.mediaRepo.GetMediaByReferences(refType, refIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range media {
+		for i := range list {
+			u.service.SetURLs(&list[i])
+		}
+	}
+	return media, nil
+}
+
+// sign replaces the public URLs of the media with signed URLs that expire, see MEDIA_SIGNED_URL_TTL.
+func (u *MediaUploader) sign(media []model.Media) {
+	for i := range media {
+		u.service.SetSignedURLs(&media[i])
+	}
+}
+
+// save writes the image files and stores the media row owned by the user.
+func (u *MediaUploader) save(img *media.Image, ownerID int) (model.Media, error) {
+	if err := u.service.Save(img); err != nil {
+		return model.Media{}, err
+	}
+	m, err := u.mediaRepo.CreateMedia(model.Media{
+		OwnerID:        ownerID,
+		Hash:           img.Hash,
+		FileName:       img.FileName(),
+		ContentType:    img.ContentType,
+		Width:          img.Width,
+		Height:         img.Height,
+		SizeBytes:      img.Size(),
+		ThumbnailSizes: img.ThumbnailSizes(),
+	})
+	if err != nil {
+		return model.Media{}, err
+	}
+	u.service.SetURLs(&m)
+	return m, nil
+}
+
+// writeUploadError responds with the status that matches an error from process.
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTooManyImages):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, media.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, media.ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, media.ErrTooManyPixels), errors.Is(err, media.ErrInvalidImage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error reading uploaded image: "+err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// TestCreateWithImagesFailure makes creating a post or comment with images fail after the images were saved,
+// and checks that neither the images nor their files are left behind.
+func TestCreateWithImagesFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		trigger string // makes the step fail, the images fail once the first one is linked
+	}{
+		{"post insert", "/post", `CREATE TRIGGER fail BEFORE INSERT ON posts BEGIN SELECT RAISE(ABORT, 'failed'); END`},
+		{"post images", "/post", `CREATE TRIGGER fail BEFORE INSERT ON media_references WHEN (SELECT COUNT(*) FROM media_references) > 0 BEGIN SELECT RAISE(ABORT, 'failed'); END`},
+		{"comment insert", "/post/comment", `CREATE TRIGGER fail BEFORE INSERT ON comments BEGIN SELECT RAISE(ABORT, 'failed'); END`},
+		{"comment images", "/post/comment", `CREATE TRIGGER fail BEFORE INSERT ON media_references WHEN (SELECT COUNT(*) FROM media_references) > 0 BEGIN SELECT RAISE(ABORT, 'failed'); END`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.addUser(t, "author")
+			postRepo := repository.NewPostRepository(s.db)
+			commentRepo := repository.NewCommentRepository(s.db)
+			friendsRepo := repository.NewFriendsRepository(s.db)
+			groupMemberRepo := repository.NewGroupMemberRepository(s.db)
+			reactionRepo := repository.NewReactionRepository(s.db)
+			visibility := NewVisibility(friendsRepo, groupMemberRepo, postRepo)
+			postHandler := NewPostHandler(postRepo, friendsRepo, groupMemberRepo, commentRepo, reactionRepo, s.mediaUploader(), visibility)
+			commentHandler := NewCommentHandler(commentRepo, reactionRepo, postRepo, repository.NewUserRepository(s.db),
+				repository.NewNotificationRepository(s.db), s.mediaUploader(), visibility, 3)
+			s.router.HandleFunc("/post", postHandler.CreatePostHandler).Methods("POST")
+			s.router.HandleFunc("/post/comment", commentHandler.CreateCommentHandler).Methods("POST")
+
+			var existing struct{ posts, comments int }
+			s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM posts), (SELECT COUNT(*) FROM comments)`).Scan(&existing.posts, &existing.comments)
+			postID, err := postRepo.CreatePost(model.CreatePostRequest{Title: "Post", PrivacySetting: "public"}, s.users["author"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.db.Exec(tt.trigger); err != nil {
+				t.Fatal(err)
+			}
+
+			w := s.upload(t, tt.path, "author", map[string]string{"title": "Post", "privacy_setting": "public", "post_id": strconv.Itoa(int(postID)), "content": "With images"}, 2)
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("POST %s = %d %s, want 500", tt.path, w.Code, w.Body)
+			}
+
+			var posts, comments, media int
+			s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM posts), (SELECT COUNT(*) FROM comments), (SELECT COUNT(*) FROM media)`).Scan(&posts, &comments, &media)
+			if posts != existing.posts+1 || comments != existing.comments {
+				t.Errorf("%d posts and %d comments were left", posts-existing.posts-1, comments-existing.comments)
+			}
+			if media != 0 {
+				t.Errorf("%d images were left", media)
+			}
+			if files, _ := os.ReadDir(s.mediaDir); len(files) != 0 {
+				t.Errorf("%d files were left", len(files))
+			}
+		})
+	}
+}
+
+// upload sends the fields and a number of different PNG images as multipart form data, as the user.
+func (s *testServer) upload(t *testing.T, path, user string, fields map[string]string, images int) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	for i := 0; i < images; i++ {
+		part, err := form.CreateFormFile("images", "image"+strconv.Itoa(i)+".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(part, image.NewGray(image.Rect(0, 0, 200+i, 200)))
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, path, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.AddCookie(&http.Cookie{Name: "session_token", Value: "session-" + user})
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
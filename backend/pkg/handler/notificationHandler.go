@@ -1,9 +1,9 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
 	"encoding/json"
 	"net/http"
 	"strconv"
@@ -14,14 +14,13 @@ import (
 // NotificationHandler handles HTTP requests related to notifications.
 type NotificationHandler struct {
 	notificationRepo *repository.NotificationRepository
-	sessionRepo      *repository.SessionRepository
 }
 
 // NewNotificationHandler creates a new instance of NotificationHandler.
-// It takes a NotificationRepository and a SessionRepository as parameters.
+// It takes a NotificationRepository as parameter.
 // Returns a pointer to the newly created NotificationHandler.
-func NewNotificationHandler(notificationRepo *repository.NotificationRepository, sessionRepo *repository.SessionRepository) *NotificationHandler {
-	return &NotificationHandler{notificationRepo: notificationRepo, sessionRepo: sessionRepo}
+func NewNotificationHandler(notificationRepo *repository.NotificationRepository) *NotificationHandler {
+	return &NotificationHandler{notificationRepo: notificationRepo}
 }
 
 // GetAllNotificationsHandler retrieves all notifications and responds
@@ -44,9 +43,9 @@ func (h *NotificationHandler) CreateNotificationHandler(w http.ResponseWriter, r
 		return
 	}
 	// check if notification with title already exists IN FRONTEND
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	newNotification.UserId = userID
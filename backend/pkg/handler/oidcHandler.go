@@ -0,0 +1,484 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/oidc"
+	"backend/pkg/repository"
+	"backend/util"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const oidcStateCookieName = "oidc_state"
+
+// usernameCharacters matches the characters that are dropped from a suggested username.
+var usernameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// OIDCHandler handles logging in with external OpenID Connect providers.
+// The login starts with a redirect to the provider and ends in CallbackHandler, which either logs in the user
+// the provider account is linked to, links the account to the logged in user, or starts a registration
+// that the frontend finishes with RegisterHandler.
+type OIDCHandler struct {
+	oidcRepo     *repository.OIDCRepository
+	users        *UserHandler
+	providers    map[string]*oidc.Provider
+	frontendURL  string
+	flowTTL      time.Duration
+	cookieConfig config.CookieConfig
+}
+
+func NewOIDCHandler(oRepo *repository.OIDCRepository, users *UserHandler, providers []*oidc.Provider, frontendURL string, flowTTL time.Duration, cookieConfig config.CookieConfig) *OIDCHandler {
+	byName := make(map[string]*oidc.Provider)
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &OIDCHandler{oidcRepo: oRepo, users: users, providers: byName, frontendURL: frontendURL, flowTTL: flowTTL, cookieConfig: cookieConfig}
+}
+
+// GetProvidersHandler lists the names of the configured providers, so the frontend can show a button for each.
+func (h *OIDCHandler) GetProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// LoginHandler redirects the browser to the login page of the provider.
+func (h *OIDCHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	h.redirectToProvider(w, r, 0)
+}
+
+// LinkHandler redirects the logged in user to the provider to link their account there to this one.
+func (h *OIDCHandler) LinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	h.redirectToProvider(w, r, userID)
+}
+
+// redirectToProvider stores a new login state and redirects to the provider's authorization endpoint.
+// The state is also set as a cookie, so the callback only accepts it in the browser that started the login.
+func (h *OIDCHandler) redirectToProvider(w http.ResponseWriter, r *http.Request, linkUserID int) {
+	provider, ok := h.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			http.Error(w, "Error generating login state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	authURL, err := provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(codeVerifier))
+	if err != nil {
+		fmt.Println("Error starting OIDC login: ", err)
+		http.Error(w, "The login provider is not available", http.StatusBadGateway)
+		return
+	}
+
+	err = h.oidcRepo.CreateLoginState(util.HashToken(state), model.OIDCLoginState{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(h.flowTTL),
+	})
+	if err != nil {
+		http.Error(w, "Error storing login state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, h.stateCookie(state, int(h.flowTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// stateCookie returns the cookie that binds a login state to the browser. It is always SameSite=Lax,
+// because a Strict cookie wouldn't be sent when the provider redirects back.
+func (h *OIDCHandler) stateCookie(state string, maxAge int) *http.Cookie {
+	cookie := h.cookieConfig.NewCookie(oidcStateCookieName, state, true)
+	cookie.Path = "/api/auth/oidc"
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.MaxAge = maxAge
+	return cookie
+}
+
+// CallbackHandler is where the provider redirects to after the login. It checks the state against the cookie,
+// exchanges the code for the ID token and validates it, and then redirects back to the frontend:
+//   - a linked account logs in (or asks for the 2FA code if the user has 2FA enabled)
+//   - a login started by LinkHandler links the account to the user who started it
+//   - an unknown account starts a registration, unless its email address belongs to an existing user,
+//     who has to log in and link the account first
+func (h *OIDCHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	providerName := mux.Vars(r)["provider"]
+	provider, ok := h.providers[providerName]
+	if !ok {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	cookie, err := r.Cookie(oidcStateCookieName)
+	http.SetCookie(w, h.stateCookie("", -1))
+	if err != nil || query.Get("state") == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		h.redirectWithError(w, r, "/auth", "invalid_state")
+		return
+	}
+
+	state, err := h.oidcRepo.ConsumeLoginState(util.HashToken(query.Get("state")))
+	if err != nil || state.Provider != provider.Name {
+		if err != nil && err != sql.ErrNoRows {
+			fmt.Println("Error getting OIDC login state: ", err)
+		}
+		h.redirectWithError(w, r, "/auth", "invalid_state")
+		return
+	}
+	errorPath := "/auth"
+	if state.LinkUserID != 0 {
+		errorPath = "/dashboard/profile"
+	}
+
+	// The user cancelled or the provider refused the login
+	if query.Get("error") != "" {
+		h.redirectWithError(w, r, errorPath, "access_denied")
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		fmt.Println("Error completing OIDC login: ", err)
+		h.redirectWithError(w, r, errorPath, "login_failed")
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		h.linkIdentity(w, r, state.LinkUserID, provider.Name, claims)
+		return
+	}
+
+	identity, err := h.oidcRepo.GetIdentity(provider.Name, claims.Subject)
+	if err == sql.ErrNoRows {
+		h.startRegistration(w, r, provider.Name, claims)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting identity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.oidcRepo.UpdateIdentityLogin(identity.Id, claims.Email); err != nil {
+		fmt.Println("Error updating identity: ", err)
+	}
+	h.logIn(w, r, identity.UserID, provider.Name)
+}
+
+// logIn starts a session for the user of a linked account. Users with 2FA still have to enter a code:
+// they are sent to the login page with an mfa_token for LoginTwoFactorHandler.
+func (h *OIDCHandler) logIn(w http.ResponseWriter, r *http.Request, userID int, providerName string) {
+	attempt := model.LoginAttempt{Username: "oidc:" + providerName, UserID: userID, IPAddress: util.GetClientIP(r), UserAgent: r.UserAgent()}
+
+	twoFactorEnabled, err := h.users.twoFactorRepo.IsEnabled(userID)
+	if err != nil {
+		http.Error(w, "Error checking two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		mfaToken := util.GenerateSessionToken()
+		err = h.users.twoFactorRepo.CreateChallenge(util.HashToken(mfaToken), model.MFAChallenge{
+			UserID:    userID,
+			ExpiresAt: time.Now().Add(h.users.twoFactorConfig.ChallengeTTL),
+		})
+		if err != nil {
+			http.Error(w, "Error creating MFA challenge: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attempt.Reason = "mfa_required"
+		h.users.recordLoginAttempt(attempt)
+		// The token goes in the fragment, which browsers don't send to servers or in the Referer header
+		http.Redirect(w, r, h.frontendURL+"/auth#mfa_token="+mfaToken, http.StatusFound)
+		return
+	}
+
+	if err := h.users.startSession(w, r, userID, false); err != nil {
+		http.Error(w, "Error creating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	attempt.Success = true
+	attempt.Reason = "success"
+	h.users.recordLoginAttempt(attempt)
+	http.Redirect(w, r, h.frontendURL+"/dashboard", http.StatusFound)
+}
+
+// linkIdentity links the provider account to the user who started the login with LinkHandler.
+func (h *OIDCHandler) linkIdentity(w http.ResponseWriter, r *http.Request, userID int, providerName string, claims oidc.Claims) {
+	identity, err := h.oidcRepo.GetIdentity(providerName, claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			h.redirectWithError(w, r, "/dashboard/profile", "identity_taken")
+			return
+		}
+	} else if err == sql.ErrNoRows {
+		err = h.oidcRepo.LinkIdentity(model.UserIdentity{UserID: userID, Provider: providerName, Subject: claims.Subject, Email: claims.Email})
+		if err == repository.ErrIdentityTaken {
+			h.redirectWithError(w, r, "/dashboard/profile", "identity_taken")
+			return
+		}
+	}
+	if err != nil {
+		http.Error(w, "Error linking identity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, h.frontendURL+"/dashboard/profile?oidc_linked="+url.QueryEscape(providerName), http.StatusFound)
+}
+
+// startRegistration stores what the provider told about an unknown account and sends the user to the
+// registration page with a token for GetRegistrationHandler and RegisterHandler.
+// Accounts are never linked to an existing user by email address, since that would let whoever controls
+// the address at the provider take over the account here.
+func (h *OIDCHandler) startRegistration(w http.ResponseWriter, r *http.Request, providerName string, claims oidc.Claims) {
+	if claims.Email != "" {
+		_, err := h.users.userRepo.GetUserByEmailOrNickname(claims.Email)
+		if err == nil {
+			h.redirectWithError(w, r, "/auth", "account_exists")
+			return
+		}
+		if err != sql.ErrNoRows {
+			http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token := util.GenerateSessionToken()
+	err := h.oidcRepo.CreateRegistration(util.HashToken(token), model.OIDCRegistration{
+		Provider:      providerName,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Prefill:       prefillRegistration(claims),
+		ExpiresAt:     time.Now().Add(h.flowTTL),
+	})
+	if err != nil {
+		http.Error(w, "Error storing registration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, h.frontendURL+"/auth/register#oidc_token="+token, http.StatusFound)
+}
+
+// prefillRegistration maps the claims of the ID token to the fields of the registration form.
+func prefillRegistration(claims oidc.Claims) model.RegistrationData {
+	data := model.RegistrationData{
+		Email:     claims.Email,
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+	}
+	if data.FirstName == "" && data.LastName == "" && claims.Name != "" {
+		names := strings.SplitN(claims.Name, " ", 2)
+		data.FirstName = names[0]
+		if len(names) == 2 {
+			data.LastName = names[1]
+		}
+	}
+	// Only full dates fit the date of birth column; providers may send just the year
+	if _, err := time.Parse("2006-01-02", claims.Birthdate); err == nil {
+		data.DOB = claims.Birthdate
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	data.Username = usernameCharacters.ReplaceAllString(username, "")
+	return data
+}
+
+// redirectWithError sends the browser back to the frontend page with an oidc_error code the page can show a message for.
+func (h *OIDCHandler) redirectWithError(w http.ResponseWriter, r *http.Request, path, code string) {
+	http.Redirect(w, r, h.frontendURL+path+"?oidc_error="+code, http.StatusFound)
+}
+
+// GetRegistrationHandler returns the prefilled registration data of the registration token in the token query parameter.
+func (h *OIDCHandler) GetRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	registration, err := h.oidcRepo.GetRegistration(util.HashToken(r.URL.Query().Get("token")))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Registration has expired, please log in again", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting registration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"provider":     registration.Provider,
+		"registration": registration.Prefill,
+	})
+}
+
+// RegisterHandler creates the account of a first login with a provider, links the provider account to it and logs in.
+// The email address counts as verified if the provider verified it and the user kept it; otherwise a verification
+// email is sent like after a normal registration. The password is optional, since the user can log in with the provider.
+func (h *OIDCHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.OIDCRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	registration, err := h.oidcRepo.GetRegistration(util.HashToken(request.Token))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Registration has expired, please log in again", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting registration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := request.RegistrationData
+	// Avatars can't be uploaded here, the user can add one to the profile later. The picture of the provider isn't
+	// used: it would be hotlinked from the provider, without the checks and access control of uploaded images
+	data.AvatarURL = ""
+	if message := validateProfileUpdate(model.ProfileUpdate{
+		Username:  &data.Username,
+		Email:     &data.Email,
+		FirstName: &data.FirstName,
+		LastName:  &data.LastName,
+	}); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", data.DOB); err != nil {
+		http.Error(w, "Invalid date of birth", http.StatusBadRequest)
+		return
+	}
+
+	if data.Password != "" {
+		if err := validatePassword(h.users.passwordPolicy, data.Password, data.Username, data.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+			return
+		}
+		data.Password = string(hashedPassword)
+	}
+
+	verified := registration.EmailVerified && strings.EqualFold(data.Email, registration.Email)
+	userID, err := h.oidcRepo.CompleteRegistration(registration, data, verified)
+	if err == repository.ErrDuplicateUser {
+		http.Error(w, "Username or email is already taken", http.StatusConflict)
+		return
+	}
+	if err == repository.ErrIdentityTaken {
+		http.Error(w, "This account is already registered, please log in", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error registering user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !verified {
+		if err := h.users.emailVerifier.sendVerification(userID, data.Email, data.FirstName); err != nil {
+			fmt.Println("Error sending verification email: ", err)
+		}
+	}
+
+	if err := h.users.startSession(w, r, userID, false); err != nil {
+		http.Error(w, "Error creating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "User registration successful",
+		"email_verified": verified,
+	})
+}
+
+// GetIdentitiesHandler lists the provider accounts linked to the logged in user.
+func (h *OIDCHandler) GetIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := h.oidcRepo.GetIdentitiesByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error getting identities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// UnlinkIdentityHandler removes the linked provider account with the ID from the URL.
+// Users without a password can't remove their last linked account, since they couldn't log in anymore.
+func (h *OIDCHandler) UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	identityID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid identity ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.users.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	identities, err := h.oidcRepo.GetIdentitiesByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error getting identities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.Password == "" && len(identities) == 1 && identities[0].Id == identityID {
+		http.Error(w, "Set a password (POST /api/users/password) before removing your only login provider", http.StatusConflict)
+		return
+	}
+
+	err = h.oidcRepo.UnlinkIdentity(identityID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error unlinking identity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Login provider removed",
+	})
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/model"
+	"backend/pkg/oidc"
+	"backend/pkg/repository"
+	"backend/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestOIDCHandler returns a handler for the provider "mock" with its login states in an in-memory database.
+// The provider isn't contacted before the state is checked, so it doesn't need to exist.
+func newTestOIDCHandler(t *testing.T) (*OIDCHandler, *repository.OIDCRepository) {
+	t.Helper()
+	oidcRepo := repository.NewOIDCRepository(newTestDB(t))
+	provider := oidc.NewProvider(config.OIDCProviderConfig{Name: "mock", Issuer: "http://127.0.0.1:1", ClientID: "client"}, "")
+	return NewOIDCHandler(oidcRepo, nil, []*oidc.Provider{provider}, "http://frontend", 10*time.Minute, config.CookieConfig{}), oidcRepo
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	tests := []struct {
+		name          string
+		storedFor     string // provider of the stored login state, "" for none
+		cookie        string // value of the state cookie, "" for no cookie
+		query         string
+		wantRedirect  string
+		wantStateLeft bool // whether the stored state can still be used
+	}{
+		{"no cookie", "mock", "", "?state=state-1&code=code", "http://frontend/auth?oidc_error=invalid_state", true},
+		{"cookie of another login", "mock", "state-2", "?state=state-1&code=code", "http://frontend/auth?oidc_error=invalid_state", true},
+		{"no state", "mock", "state-1", "?code=code", "http://frontend/auth?oidc_error=invalid_state", true},
+		{"unknown state", "", "state-1", "?state=state-1&code=code", "http://frontend/auth?oidc_error=invalid_state", false},
+		{"state of another provider", "other", "state-1", "?state=state-1&code=code", "http://frontend/auth?oidc_error=invalid_state", false},
+		// A matching state is accepted, and the provider's error is reported instead
+		{"matching state", "mock", "state-1", "?state=state-1&error=access_denied", "http://frontend/auth?oidc_error=access_denied", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, oidcRepo := newTestOIDCHandler(t)
+			if tt.storedFor != "" {
+				err := oidcRepo.CreateLoginState(util.HashToken("state-1"), model.OIDCLoginState{
+					Provider:     tt.storedFor,
+					Nonce:        "nonce",
+					CodeVerifier: "verifier",
+					ExpiresAt:    time.Now().Add(time.Minute),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback"+tt.query, nil)
+			r = mux.SetURLVars(r, map[string]string{"provider": "mock"})
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: oidcStateCookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.CallbackHandler(w, r)
+
+			if w.Code != http.StatusFound || w.Header().Get("Location") != tt.wantRedirect {
+				t.Errorf("response = %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusFound, tt.wantRedirect)
+			}
+			// The state cookie is only good for one callback
+			cleared := false
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == oidcStateCookieName && cookie.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if !cleared {
+				t.Error("the state cookie was not cleared")
+			}
+
+			_, err := oidcRepo.ConsumeLoginState(util.HashToken("state-1"))
+			if stateLeft := err == nil; stateLeft != tt.wantStateLeft {
+				t.Errorf("state left = %v, want %v", stateLeft, tt.wantStateLeft)
+			}
+		})
+	}
+}
+
+func TestPrefillRegistration(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims oidc.Claims
+		want   model.RegistrationData
+	}{
+		{"all claims", oidc.Claims{Email: "bob@example.com", GivenName: "Bob", FamilyName: "Smith", PreferredUsername: "bob.smith", Birthdate: "1990-05-01"},
+			model.RegistrationData{Username: "bob.smith", Email: "bob@example.com", FirstName: "Bob", LastName: "Smith", DOB: "1990-05-01"}},
+		{"name and email only", oidc.Claims{Email: "bob+test@example.com", Name: "Bob van Smith"},
+			model.RegistrationData{Username: "bobtest", Email: "bob+test@example.com", FirstName: "Bob", LastName: "van Smith"}},
+		{"year of birth", oidc.Claims{PreferredUsername: "bob", Birthdate: "1990"}, model.RegistrationData{Username: "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefillRegistration(tt.claims); got != tt.want {
+				t.Errorf("prefillRegistration() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
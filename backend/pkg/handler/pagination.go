@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"backend/pkg/model"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Lists ordered by created_at and ID (the feed, comments) are paginated with ?limit= and an opaque ?after= cursor.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageParams reads the ?limit= and ?after= parameters. after is nil for the first page.
+// The returned error message can be sent to the client.
+func pageParams(r *http.Request) (int, *model.Cursor, error) {
+	limit := defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, nil, fmt.Errorf("invalid limit, it must be between 1 and %d", maxPageLimit)
+		}
+	}
+	value := r.URL.Query().Get("after")
+	if value == "" {
+		return limit, nil, nil
+	}
+	after, err := decodeCursor(value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cursor")
+	}
+	return limit, after, nil
+}
+
+// encodeCursor makes the cursor of the page after an item: its creation time and ID.
+func encodeCursor(createdAt time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", createdAt.Unix(), id)))
+}
+
+func decodeCursor(value string) (*model.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var unix int64
+	var id int
+	if _, err := fmt.Sscanf(string(data), "%d:%d", &unix, &id); err != nil {
+		return nil, err
+	}
+	return &model.Cursor{CreatedAt: time.Unix(unix, 0), ID: id}, nil
+}
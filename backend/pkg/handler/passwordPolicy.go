@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// maxPasswordBytes is the bcrypt input limit; longer passwords would be silently truncated.
+const maxPasswordBytes = 72
+
+// validatePassword checks a new password against the policy. personal holds values the password must not be
+// equal to, like the username and email address. The returned error message can be shown to the user.
+func validatePassword(policy config.PasswordPolicyConfig, password string, personal ...string) error {
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Errorf("Password must be at least %d characters long", policy.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes long", maxPasswordBytes)
+	}
+
+	if policy.RequireMixed {
+		hasLetter, hasOther := false, false
+		for _, r := range password {
+			if unicode.IsLetter(r) {
+				hasLetter = true
+			} else if !unicode.IsSpace(r) {
+				hasOther = true
+			}
+		}
+		if !hasLetter || !hasOther {
+			return errors.New("Password must contain a letter and a digit or symbol")
+		}
+	}
+
+	for _, value := range personal {
+		if value != "" && strings.EqualFold(password, value) {
+			return errors.New("Password must not be your username or email")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/mail"
+	"backend/pkg/model"
+	"backend/pkg/ratelimit"
+	"backend/pkg/repository"
+	"backend/pkg/ws"
+	"backend/util"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordResetHandler struct {
+	userRepo          *repository.UserRepository
+	passwordResetRepo *repository.PasswordResetRepository
+	sessionRepo       *repository.SessionRepository
+	apiTokenRepo      *repository.APITokenRepository
+	loginLimiter      *ratelimit.LoginLimiter
+	hub               *ws.Hub
+	mailer            mail.Mailer
+	frontendURL       string
+	tokenTTL          time.Duration
+	resendInterval    time.Duration
+	passwordPolicy    config.PasswordPolicyConfig
+}
+
+func NewPasswordResetHandler(uRepo *repository.UserRepository, prRepo *repository.PasswordResetRepository, sRepo *repository.SessionRepository, atRepo *repository.APITokenRepository, loginLimiter *ratelimit.LoginLimiter, hub *ws.Hub, mailer mail.Mailer, frontendURL string, tokenTTL, resendInterval time.Duration, passwordPolicy config.PasswordPolicyConfig) *PasswordResetHandler {
+	return &PasswordResetHandler{userRepo: uRepo, passwordResetRepo: prRepo, sessionRepo: sRepo, apiTokenRepo: atRepo, loginLimiter: loginLimiter, hub: hub, mailer: mailer, frontendURL: frontendURL, tokenTTL: tokenTTL, resendInterval: resendInterval, passwordPolicy: passwordPolicy}
+}
+
+// ForgotPasswordHandler emails a password reset link to the user with the given email address.
+// It always responds with the same message, so it can't be used to find out which addresses have an account.
+// Only one email per resendInterval is sent to a user, so the endpoint can't be used to flood an inbox;
+// the requests in between are answered the same but do nothing.
+func (h *PasswordResetHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByEmailOrNickname(request.Email)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		lastSentAt, err := h.passwordResetRepo.GetLastTokenCreatedAt(user.Id)
+		if err != nil {
+			http.Error(w, "Error getting password reset token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if time.Since(lastSentAt) < h.resendInterval {
+			writeResetLinkSent(w)
+			return
+		}
+
+		// Only the hash is stored, the token itself is only in the email
+		token := util.GenerateSessionToken()
+		err = h.passwordResetRepo.CreateToken(user.Id, util.HashToken(token), time.Now().Add(h.tokenTTL))
+		if err != nil {
+			http.Error(w, "Error creating password reset token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Sent in the background so the response time doesn't reveal whether the account exists
+		go h.sendResetMail(user, token)
+	}
+	writeResetLinkSent(w)
+}
+
+// writeResetLinkSent sends the response of every valid forgot password request.
+func writeResetLinkSent(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account with that email exists, a password reset link has been sent",
+	})
+}
+
+func (h *PasswordResetHandler) sendResetMail(user model.User, token string) {
+	link := h.frontendURL + "/auth/reset-password?token=" + url.QueryEscape(token)
+	err := h.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your IrieSphere password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your IrieSphere account. "+
+			"Open the link below to choose a new password. It is valid for %d minutes and can only be used once.\n\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.\n", user.FirstName, int(h.tokenTTL.Minutes()), link),
+	})
+	if err != nil {
+		fmt.Println("Error sending password reset email: ", err)
+	}
+}
+
+// ResetPasswordHandler sets a new password with a token from a password reset email.
+// The token can only be used once. All sessions of the user are logged out and the login rate limit of the account is reset.
+func (h *PasswordResetHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// The policy is checked before the token is used up, so a rejected password can be corrected
+	if err := validatePassword(h.passwordPolicy, request.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.passwordResetRepo.ConsumeToken(util.HashToken(request.Token))
+	if err == repository.ErrInvalidResetToken {
+		http.Error(w, "Password reset link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error checking password reset token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.userRepo.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		http.Error(w, "Error updating password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	revokedTokens, err := h.sessionRepo.DeleteOtherUserSessions(userID, "")
+	if err != nil {
+		http.Error(w, "Error revoking sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseSessionConnections(revokedTokens...)
+	// API tokens could have been created by whoever took over the account too
+	revokedAPITokens, err := h.apiTokenRepo.DeleteUserTokens(userID)
+	if err != nil {
+		http.Error(w, "Error revoking API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseAPITokenConnections(revokedAPITokens...)
+	if err := h.loginLimiter.RegisterSuccess(ratelimit.UserKey(userID)); err != nil {
+		fmt.Println("Error resetting login failures: ", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
@@ -1,76 +1,267 @@
 package handler
 
 import (
+	"backend/pkg/media"
+	"backend/pkg/middleware"
 	"backend/pkg/model"
 	"backend/pkg/repository"
-	"backend/util"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+// postDetailComments is how many comments GetPostByIDHandler includes
+const postDetailComments = 20
+
 type PostHandler struct {
 	postRepo *repository.PostRepository
-	sessionRepo *repository.SessionRepository
 	friendsRepo *repository.FriendsRepository
 	groupMemberRepo *repository.GroupMemberRepository
+	commentRepo *repository.CommentRepository
+	reactionRepo *repository.ReactionRepository
+	mediaUploader *MediaUploader
+	visibility *Visibility
 }
 
-func NewPostHandler(postRepo *repository.PostRepository, sessionRepo *repository.SessionRepository, friendsRepo *repository.FriendsRepository, groupMemberRepo *repository.GroupMemberRepository) *PostHandler {
-	return &PostHandler{postRepo: postRepo, sessionRepo: sessionRepo, friendsRepo: friendsRepo, groupMemberRepo: groupMemberRepo}
+func NewPostHandler(postRepo *repository.PostRepository, friendsRepo *repository.FriendsRepository, groupMemberRepo *repository.GroupMemberRepository, commentRepo *repository.CommentRepository, reactionRepo *repository.ReactionRepository, mediaUploader *MediaUploader, visibility *Visibility) *PostHandler {
+	return &PostHandler{postRepo: postRepo, friendsRepo: friendsRepo, groupMemberRepo: groupMemberRepo, commentRepo: commentRepo, reactionRepo: reactionRepo, mediaUploader: mediaUploader, visibility: visibility}
 }
 
+// CreatePostHandler accepts JSON, or multipart form data with the images of the post in the "images" field.
 func (h *PostHandler) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming authentication: " + err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	var request model.CreatePostRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
+	var images []*media.Image
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // Maximum memory 10MB, larger uploads go to temporary files
+			http.Error(w, "Error parsing form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Title = r.FormValue("title")
+		request.Content = r.FormValue("content")
+		request.PrivacySetting = r.FormValue("privacy_setting")
+		for _, id := range r.Form["audience"] {
+			audienceID, err := strconv.Atoi(id)
+			if err != nil {
+				http.Error(w, "Invalid audience user ID: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			request.Audience = append(request.Audience, audienceID)
+		}
+		if groupID := r.FormValue("group_id"); groupID != "" {
+			request.GroupID, err = strconv.Atoi(groupID)
+			if err != nil {
+				http.Error(w, "Invalid group ID: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		images, err = h.mediaUploader.processAll(r, "images")
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, "Failed to decode request data", http.StatusBadRequest)
+			return
+		}
+	}
+	request.Audience, err = h.checkAudience(userID, request.PrivacySetting, request.GroupID, request.Audience)
 	if err != nil {
-		http.Error(w, "Failed to decode request data", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if request.GroupID != 0 {
+		canPost, err := h.visibility.CanPostInGroup(userID, request.GroupID)
+		if err != nil {
+			http.Error(w, "Failed to check if user is in group: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !canPost {
+			http.Error(w, "User is not a member of the group", http.StatusForbidden)
+			return
+		}
+	}
 
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	// image_url is the first uploaded image for older clients, it can't point anywhere else
+	saved, err := h.mediaUploader.saveAll(images, userID)
 	if err != nil {
-		http.Error(w, "Error confirming authentication: " + err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to save the images: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	request.ImageURL = ""
+	if len(saved) > 0 {
+		request.ImageURL = saved[0].URL
+	}
 
 	// Creates the post in database
 	postID, err := h.postRepo.CreatePost(request, userID)
 	if err != nil {
+		if err := h.mediaUploader.discard(saved); err != nil {
+			fmt.Println("Error removing unused images: ", err)
+		}
 		http.Error(w, "Failed to create the post: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := h.mediaUploader.link(saved, postRefType, int(postID)); err != nil {
+		// The post is removed again, so retrying doesn't leave a copy without images
+		if _, err := h.postRepo.DeletePost(int(postID), userID); err != nil {
+			fmt.Println("Error removing post without images: ", err)
+		}
+		if err := h.mediaUploader.abandon(saved, postRefType, int(postID)); err != nil {
+			fmt.Println("Error removing unused images: ", err)
+		}
+		http.Error(w, "Failed to attach the images: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isRestrictedPost(model.Post{PrivacySetting: request.PrivacySetting, GroupID: request.GroupID}) {
+		h.mediaUploader.sign(saved)
+	}
 
 	// Successful response
 	response := map[string]interface{}{
 		"message": "Post created successfully",
 		"data": postID,
+		"images": saved,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetPostByIDHandler returns a single post with its author, images, comment count and first comments.
+// Posts the user can't see get a 404 like posts that don't exist.
+func (h *PostHandler) GetPostByIDHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to parse post ID: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming user authentication: " + err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	post, err := h.postRepo.GetPostWithAuthor(postID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve the post: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	canView, err := h.visibility.CanViewPost(userID, post)
+	if err != nil {
+		http.Error(w, "Failed to check post visibility: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !canView {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	posts := []model.Post{post}
+	if err := h.attachImages(posts); err != nil {
+		http.Error(w, "Failed to retrieve images: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.attachAudiences(posts, userID); err != nil {
+		http.Error(w, "Failed to retrieve post audience: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachPostReactions(h.reactionRepo, posts, userID); err != nil {
+		http.Error(w, "Failed to retrieve reactions: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	detail := model.PostDetail{Post: posts[0]}
+	detail.CommentCount, err = h.commentRepo.CountPostComments(postID)
+	if err != nil {
+		http.Error(w, "Failed to count comments: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The first page of top-level comments, one extra tells whether there are more
+	detail.Comments, err = h.commentRepo.GetCommentPage(postID, 0, nil, postDetailComments+1)
+	if err != nil {
+		http.Error(w, "Failed to retrieve comments: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(detail.Comments) > postDetailComments {
+		detail.Comments = detail.Comments[:postDetailComments]
+		last := detail.Comments[postDetailComments-1]
+		detail.CommentsNextCursor = encodeCursor(last.CreatedAt, last.Id)
+	}
+	if err := attachCommentImages(h.mediaUploader, detail.Comments); err != nil {
+		http.Error(w, "Failed to retrieve images: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachCommentReactions(h.reactionRepo, detail.Comments, userID); err != nil {
+		http.Error(w, "Failed to retrieve reactions: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isRestrictedPost(post) {
+		for _, comment := range detail.Comments {
+			h.mediaUploader.sign(comment.Images)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// EditPostHandler updates the post with the ID of the URL. The images and image_url of the post are kept,
+// they can't be changed by an edit.
 func (h *PostHandler) EditPostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to parse post ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Decode the request body for updating the post
 	var request model.UpdatePostRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
+	err = json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		http.Error(w, "Failed to decode request data", http.StatusBadRequest)
 		return
 	}
 
 	// Confirm user auth and get userid
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: " + err.Error(), http.StatusUnauthorized)
 		return
 	}
 
+	post, err := h.postRepo.GetPostByID(postID)
+	if err == sql.ErrNoRows || (err == nil && post.UserID != userID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve the post: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	request.Audience, err = h.checkAudience(userID, request.PrivacySetting, post.GroupID, request.Audience)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Update the post in the database
-	err = h.postRepo.UpdatePost(request.Id, userID, request)
+	err = h.postRepo.UpdatePost(postID, userID, request)
 	if err != nil {
 		http.Error(w, "Failed to update the post: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -99,7 +290,7 @@ func (h *PostHandler) DeletePostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userId, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userId, err := middleware.GetUserID(r)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -107,11 +298,23 @@ func (h *PostHandler) DeletePostHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Delete the post from the database
-	err = h.postRepo.DeletePost(intpostID, userId)
+	commentIDs, err := h.postRepo.DeletePost(intpostID, userId)
 	if err != nil {
 		http.Error(w, "Failed to delete the post: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// The post is gone either way, images that can't be cleaned up now are only orphaned
+	if err := h.mediaUploader.release(postRefType, intpostID); err != nil {
+		fmt.Println("Error deleting images of post: ", err)
+	}
+	for _, commentID := range commentIDs {
+		if err := h.mediaUploader.release(commentRefType, commentID); err != nil {
+			fmt.Println("Error deleting images of comment: ", err)
+		}
+	}
+	if err := h.reactionRepo.DeleteReactions(postRefType, intpostID); err != nil {
+		fmt.Println("Error deleting reactions of post: ", err)
+	}
 
 	// Successful response
 	response := map[string]string{
@@ -122,35 +325,54 @@ func (h *PostHandler) DeletePostHandler(w http.ResponseWriter, r *http.Request)
 }
 
 
+// GetAllPostsHandler returns a page of the home feed: ?limit= posts (default 20, at most 100), newest first,
+// after the ?after= cursor. The next_cursor of the response gets the next page.
 func (h *PostHandler) GetAllPostsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: " + err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	userGroupsPosts, err := h.postRepo.GetPostsByUserGroups(userID)
+	limit, after, err := pageParams(r)
 	if err != nil {
-		http.Error(w, "Failed to retrieve posts by user groups: " + err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	posts, err := h.postRepo.GetAllPostsWithUserIDAccess(userID)
+
+	// One extra post tells whether there is a next page
+	posts, err := h.postRepo.GetFeed(userID, after, limit+1)
 	if err != nil {
 		http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
 		return
 	}
-	posts = append(posts, userGroupsPosts...)
+	page := model.FeedPage{Posts: posts}
+	if len(posts) > limit {
+		page.Posts = posts[:limit]
+		page.NextCursor = encodeCursor(page.Posts[limit-1].CreatedAt, page.Posts[limit-1].Id)
+	}
+	if err := h.attachImages(page.Posts); err != nil {
+		http.Error(w, "Failed to retrieve images: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.attachAudiences(page.Posts, userID); err != nil {
+		http.Error(w, "Failed to retrieve post audiences: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachPostReactions(h.reactionRepo, page.Posts, userID); err != nil {
+		http.Error(w, "Failed to retrieve reactions: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(page)
 }
 
 // GetAllUserPosts retrieves all posts for a specific user.
 // It takes the user ID from the request parameters and checks the user's authentication.
 // If the requesting user is the same as the user ID in the parameters, it retrieves all posts for that user.
-// If the requesting user is not the same, it checks the friend status between the requesting user and the user ID in the parameters.
-// If they are friends, it retrieves all posts for that user.
-// If they are not friends, it retrieves only the public posts for that user.
+// Otherwise it retrieves the public posts, the private posts if they are friends and the custom posts the
+// requesting user was picked for.
 // The retrieved posts are encoded as JSON and sent in the response.
 func (h *PostHandler) GetAllUserPostsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -164,7 +386,7 @@ func (h *PostHandler) GetAllUserPostsHandler(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "User ID is missing in parameters", http.StatusBadRequest)
 		return
 	}
-	requestingUserID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	requestingUserID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: " + err.Error(), http.StatusUnauthorized)
 		return
@@ -172,36 +394,108 @@ func (h *PostHandler) GetAllUserPostsHandler(w http.ResponseWriter, r *http.Requ
 	var posts []model.Post
 	if requestingUserID == intUserID {
 		posts, err = h.postRepo.GetAllUserPosts(requestingUserID)
-		if err != nil {
-			http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
-			return
-		}
 	} else {
-		// check if the users are friends
-		status, err := h.friendsRepo.GetFriendStatus(requestingUserID, intUserID)
-		if err != nil {
-			http.Error(w, "Failed to retrieve friend status: " + err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// retrieve users public posts, and private posts if they are friends
-		if status == "accepted" {
-			posts, err = h.postRepo.GetAllUserPosts(intUserID)
-			if err != nil {
-				http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			posts, err = h.postRepo.GetAllUserPublicPosts(intUserID)
-			if err != nil {
-				http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
+		posts, err = h.postRepo.GetUserPostsVisibleTo(intUserID, requestingUserID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.attachImages(posts); err != nil {
+		http.Error(w, "Failed to retrieve images: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.attachAudiences(posts, requestingUserID); err != nil {
+		http.Error(w, "Failed to retrieve post audiences: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachPostReactions(h.reactionRepo, posts, requestingUserID); err != nil {
+		http.Error(w, "Failed to retrieve reactions: " + err.Error(), http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
 }
 
+// attachImages fills in the images of the posts with one query.
+func (h *PostHandler) attachImages(posts []model.Post) error {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	images, err := h.mediaUploader.images(postRefType, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Images = images[posts[i].Id]
+		if posts[i].Images == nil {
+			posts[i].Images = []model.Media{}
+		}
+		// Links to images of posts that not everyone can see expire, so they can't be passed around
+		if isRestrictedPost(posts[i]) && len(posts[i].Images) > 0 {
+			h.mediaUploader.sign(posts[i].Images)
+			posts[i].ImageURL = posts[i].Images[0].URL
+		}
+	}
+	return nil
+}
+
+// attachAudiences fills in who can see the viewer's own custom posts, so they can be edited. Others don't see the audience.
+func (h *PostHandler) attachAudiences(posts []model.Post, viewerID int) error {
+	ids := []int{}
+	for _, post := range posts {
+		if post.PrivacySetting == "custom" && post.UserID == viewerID {
+			ids = append(ids, post.Id)
+		}
+	}
+	audiences, err := h.postRepo.GetPostAudiences(ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Audience = audiences[posts[i].Id]
+	}
+	return nil
+}
+
+// checkAudience validates the privacy setting of a post and returns the audience to store: the friends who can
+// see a custom post, without duplicates. Only custom posts have an audience, and group posts can't be custom.
+func (h *PostHandler) checkAudience(userID int, privacySetting string, groupID int, audience []int) ([]int, error) {
+	switch privacySetting {
+	case "public", "private":
+		return nil, nil
+	case "custom":
+	default:
+		return nil, fmt.Errorf("invalid privacy setting, it must be public, private or custom")
+	}
+	if groupID != 0 {
+		return nil, fmt.Errorf("group posts can't have a custom audience")
+	}
+	if len(audience) == 0 {
+		return nil, fmt.Errorf("custom posts need an audience of at least one friend")
+	}
+	seen := make(map[int]bool)
+	unique := []int{}
+	for _, id := range audience {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		status, err := h.friendsRepo.GetFriendStatus(userID, id)
+		if (err != nil && err != sql.ErrNoRows) || status != "accepted" {
+			return nil, fmt.Errorf("user %d is not your friend", id)
+		}
+		unique = append(unique, id)
+	}
+	return unique, nil
+}
+
+// isRestrictedPost reports whether the post is only visible to some users: private, custom or in a group.
+func isRestrictedPost(post model.Post) bool {
+	return post.PrivacySetting != "public" || post.GroupID != 0
+}
+
 // ---------------------------------------------- //
 // ------------ Group Posts Handlers ------------ //
 // ---------------------------------------------- //
@@ -219,7 +513,7 @@ func (h *PostHandler) GetPostsByGroupIDHandler(w http.ResponseWriter, r *http.Re
 		http.Error(w, "Group ID is missing in parameters", http.StatusBadRequest)
 		return
 	}
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
 		http.Error(w, "Error confirming user authentication: " + err.Error(), http.StatusUnauthorized)
 		return
@@ -240,6 +534,14 @@ func (h *PostHandler) GetPostsByGroupIDHandler(w http.ResponseWriter, r *http.Re
 		http.Error(w, "Failed to retrieve posts: " + err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := h.attachImages(posts); err != nil {
+		http.Error(w, "Failed to retrieve images: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := attachPostReactions(h.reactionRepo, posts, userID); err != nil {
+		http.Error(w, "Failed to retrieve reactions: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
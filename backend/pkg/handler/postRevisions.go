@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"backend/pkg/diff"
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GetPostRevisionsHandler lists the earlier versions of an edited post, newest first.
+// Everyone who can see the post can see how it changed, but only the versions they could have seen
+// (see Visibility.CanViewPostRevision).
+func (h *PostHandler) GetPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := h.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := middleware.GetUserID(r)
+	revisions, err := h.postRepo.GetPostRevisions(post.Id)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the edit history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visible := []model.PostRevision{}
+	for _, revision := range revisions {
+		canView, err := h.visibility.CanViewPostRevision(userID, post, revision)
+		if err != nil {
+			http.Error(w, "Failed to check post visibility: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if canView {
+			visible = append(visible, revision)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// GetPostDiffHandler compares two versions of a post, ?from= and ?to=. By default it shows the last edit the user
+// can see: from the previous version they could have seen to the current one. Other versions get a 404.
+func (h *PostHandler) GetPostDiffHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := h.visiblePost(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := middleware.GetUserID(r)
+	revisions, err := h.postRepo.GetPostRevisions(post.Id)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the edit history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// versions[n-1] is version n, the last one is the current post; visible tells which the user can compare
+	versions := make([]model.PostRevision, len(revisions)+1)
+	visible := make([]bool, len(revisions)+1)
+	for _, revision := range revisions {
+		if revision.Version >= 1 && revision.Version <= len(revisions) {
+			versions[revision.Version-1] = revision
+			visible[revision.Version-1], err = h.visibility.CanViewPostRevision(userID, post, revision)
+			if err != nil {
+				http.Error(w, "Failed to check post visibility: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	versions[len(revisions)] = model.PostRevision{Title: post.Title, Content: post.Content, PrivacySetting: post.PrivacySetting}
+	visible[len(revisions)] = true
+
+	to, err := versionParam(r, "to", len(versions), len(versions))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// By default from is the previous version the user can see, or to itself if there is none
+	previous := to
+	for version := to - 1; version >= 1; version-- {
+		if visible[version-1] {
+			previous = version
+			break
+		}
+	}
+	from, err := versionParam(r, "from", previous, len(versions))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !visible[from-1] || !visible[to-1] {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	old, new := versions[from-1], versions[to-1]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.PostDiff{
+		From:           from,
+		To:             to,
+		Title:          diff.Words(old.Title, new.Title),
+		Content:        diff.Words(old.Content, new.Content),
+		PrivacySetting: diff.Words(old.PrivacySetting, new.PrivacySetting),
+	})
+}
+
+// versionParam reads a version number between 1 and latest from the query, or returns the default.
+func versionParam(r *http.Request, name string, defaultVersion, latest int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultVersion, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 || version > latest {
+		return 0, fmt.Errorf("invalid %s, versions go from 1 to %d", name, latest)
+	}
+	return version, nil
+}
+
+// visiblePost returns the post with the ID of the URL if the user can see it, or responds with an error.
+// Posts the user can't see get a 404 like posts that don't exist.
+func (h *PostHandler) visiblePost(w http.ResponseWriter, r *http.Request) (model.Post, bool) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to parse post ID: "+err.Error(), http.StatusBadRequest)
+		return model.Post{}, false
+	}
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
+		return model.Post{}, false
+	}
+
+	post, err := h.postRepo.GetPostByID(postID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return model.Post{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve the post: "+err.Error(), http.StatusInternalServerError)
+		return model.Post{}, false
+	}
+	canView, err := h.visibility.CanViewPost(userID, post)
+	if err != nil {
+		http.Error(w, "Failed to check post visibility: "+err.Error(), http.StatusInternalServerError)
+		return model.Post{}, false
+	}
+	if !canView {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return model.Post{}, false
+	}
+	return post, true
+}
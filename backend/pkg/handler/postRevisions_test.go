@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// TestPostRevisionVisibility edits a private post to a custom one and then to a public one. Users who can see
+// the public post now only see the earlier versions that were shown to them.
+func TestPostRevisionVisibility(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "author", "friend", "picked", "stranger")
+	for _, name := range []string{"friend", "picked"} {
+		if _, err := s.db.Exec(`INSERT INTO friends (user_id1, user_id2, status, action_user_id) VALUES (?, ?, 'accepted', ?)`,
+			s.users["author"], s.users[name], s.users["author"]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	postRepo := repository.NewPostRepository(s.db)
+	postID, err := postRepo.CreatePost(model.CreatePostRequest{Title: "Post", Content: "for friends", PrivacySetting: "private"}, s.users["author"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits := []model.UpdatePostRequest{
+		{Title: "Post", Content: "for one friend", PrivacySetting: "custom", Audience: []int{s.users["picked"]}},
+		{Title: "Post", Content: "for everyone", PrivacySetting: "public"},
+	}
+	for _, edit := range edits {
+		if err := postRepo.UpdatePost(int(postID), s.users["author"], edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	friendsRepo := repository.NewFriendsRepository(s.db)
+	groupMemberRepo := repository.NewGroupMemberRepository(s.db)
+	commentRepo := repository.NewCommentRepository(s.db)
+	postHandler := NewPostHandler(postRepo, friendsRepo, groupMemberRepo, commentRepo, repository.NewReactionRepository(s.db),
+		s.mediaUploader(), NewVisibility(friendsRepo, groupMemberRepo, postRepo))
+	s.router.HandleFunc("/post/{id}/revisions", postHandler.GetPostRevisionsHandler).Methods("GET")
+	s.router.HandleFunc("/post/{id}/revisions/diff", postHandler.GetPostDiffHandler).Methods("GET")
+	path := "/post/" + strconv.Itoa(int(postID)) + "/revisions"
+
+	tests := []struct {
+		user         string
+		wantVersions []int
+		wantFrom     int // of the default diff
+	}{
+		{"author", []int{2, 1}, 2},
+		{"friend", []int{1}, 1},
+		// The post is public now, but the audience of the custom version isn't kept
+		{"picked", []int{1}, 1},
+		{"stranger", []int{}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			w := s.do(http.MethodGet, path, tt.user)
+			var revisions []model.PostRevision
+			if err := json.NewDecoder(w.Body).Decode(&revisions); w.Code != http.StatusOK || err != nil {
+				t.Fatalf("GET revisions = %d, %v", w.Code, err)
+			}
+			versions := []int{}
+			for _, revision := range revisions {
+				versions = append(versions, revision.Version)
+			}
+			if fmt.Sprint(versions) != fmt.Sprint(tt.wantVersions) {
+				t.Errorf("versions = %v, want %v", versions, tt.wantVersions)
+			}
+
+			w = s.do(http.MethodGet, path+"/diff", tt.user)
+			var postDiff model.PostDiff
+			if err := json.NewDecoder(w.Body).Decode(&postDiff); w.Code != http.StatusOK || err != nil {
+				t.Fatalf("GET diff = %d, %v", w.Code, err)
+			}
+			if postDiff.From != tt.wantFrom || postDiff.To != 3 {
+				t.Errorf("default diff = %d to %d, want %d to 3", postDiff.From, postDiff.To, tt.wantFrom)
+			}
+
+			// Every version that isn't listed can't be compared either
+			for version := 1; version <= 2; version++ {
+				listed := false
+				for _, v := range versions {
+					listed = listed || v == version
+				}
+				wantStatus := http.StatusNotFound
+				if listed {
+					wantStatus = http.StatusOK
+				}
+				if w := s.do(http.MethodGet, path+"/diff?from="+strconv.Itoa(version), tt.user); w.Code != wantStatus {
+					t.Errorf("diff from version %d = %d, want %d", version, w.Code, wantStatus)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ReactionHandler lets users react to posts and comments they can see.
+type ReactionHandler struct {
+	reactionRepo     *repository.ReactionRepository
+	postRepo         *repository.PostRepository
+	commentRepo      *repository.CommentRepository
+	userRepo         *repository.UserRepository
+	notificationRepo *repository.NotificationRepository
+	visibility       *Visibility
+}
+
+func NewReactionHandler(reactionRepo *repository.ReactionRepository, postRepo *repository.PostRepository, commentRepo *repository.CommentRepository, userRepo *repository.UserRepository, notificationRepo *repository.NotificationRepository, visibility *Visibility) *ReactionHandler {
+	return &ReactionHandler{reactionRepo: reactionRepo, postRepo: postRepo, commentRepo: commentRepo, userRepo: userRepo, notificationRepo: notificationRepo, visibility: visibility}
+}
+
+// ReactToPostHandler toggles the user's reaction to the post, see ReactionRepository.ToggleReaction.
+func (h *ReactionHandler) ReactToPostHandler(w http.ResponseWriter, r *http.Request) {
+	h.react(w, r, postRefType)
+}
+
+// ReactToCommentHandler toggles the user's reaction to the comment.
+func (h *ReactionHandler) ReactToCommentHandler(w http.ResponseWriter, r *http.Request) {
+	h.react(w, r, commentRefType)
+}
+
+// react toggles the reaction of the request body on the post or comment with the ID of the URL and responds with its
+// new reaction summary. The author of the post or comment is notified of new reactions.
+func (h *ReactionHandler) react(w http.ResponseWriter, r *http.Request, targetType string) {
+	targetID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to parse ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var request model.ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Failed to decode request data", http.StatusBadRequest)
+		return
+	}
+	if !isReactionKind(request.Reaction) {
+		http.Error(w, fmt.Sprintf("Invalid reaction, it must be one of %v", model.ReactionKinds), http.StatusBadRequest)
+		return
+	}
+
+	authorID, title, found, err := h.target(userID, targetType, targetID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve the "+targetType+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	added, err := h.reactionRepo.ToggleReaction(userID, targetType, targetID, request.Reaction)
+	if err != nil {
+		http.Error(w, "Failed to save the reaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if added && authorID != userID {
+		// The reaction is saved either way
+		if err := h.notify(userID, authorID, targetType, targetID, title); err != nil {
+			fmt.Println("Error notifying about reaction: ", err)
+		}
+	}
+
+	summaries, err := h.reactionRepo.GetReactionSummaries(targetType, []int{targetID}, userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve reactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withCounts(summaries[targetID]))
+}
+
+// target returns the author and title of the post, or the comment's post, if the user can see it.
+func (h *ReactionHandler) target(userID int, targetType string, targetID int) (int, string, bool, error) {
+	postID := targetID
+	authorID := 0
+	if targetType == commentRefType {
+		comment, err := h.commentRepo.GetCommentByID(targetID)
+		if err == sql.ErrNoRows || (err == nil && comment.Deleted) {
+			return 0, "", false, nil
+		}
+		if err != nil {
+			return 0, "", false, err
+		}
+		postID = comment.PostID
+		authorID = comment.UserID
+	}
+	post, err := h.postRepo.GetPostByID(postID)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	canView, err := h.visibility.CanViewPost(userID, post)
+	if err != nil || !canView {
+		return 0, "", false, err
+	}
+	if targetType == postRefType {
+		authorID = post.UserID
+	}
+	return authorID, post.Title, true, nil
+}
+
+// notify tells the author about the reaction. All unread reactions to the same post or comment share one notification.
+func (h *ReactionHandler) notify(userID, authorID int, targetType string, targetID int, title string) error {
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	reactors, err := h.reactionRepo.CountReactors(targetType, targetID, authorID)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("your post %q", title)
+	if targetType == commentRefType {
+		subject = fmt.Sprintf("your comment on %q", title)
+	}
+	message := user.Username + " reacted to " + subject
+	if reactors > 1 {
+		message = fmt.Sprintf("%s and %d others reacted to %s", user.Username, reactors-1, subject)
+	}
+	return h.notificationRepo.MergeNotification(model.Notification{
+		UserId:  authorID,
+		Type:    targetType + "_reaction",
+		Message: message,
+		RefType: targetType,
+		RefID:   targetID,
+	})
+}
+
+func isReactionKind(reaction string) bool {
+	for _, kind := range model.ReactionKinds {
+		if reaction == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// withCounts makes sure the summary has a counts object, so posts without reactions have "counts": {}.
+func withCounts(summary model.ReactionSummary) model.ReactionSummary {
+	if summary.Counts == nil {
+		summary.Counts = make(map[string]int)
+	}
+	return summary
+}
+
+// attachPostReactions fills in the reaction summaries of the posts for the viewer with one query.
+func attachPostReactions(reactionRepo *repository.ReactionRepository, posts []model.Post, viewerID int) error {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	summaries, err := reactionRepo.GetReactionSummaries(postRefType, ids, viewerID)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Reactions = withCounts(summaries[posts[i].Id])
+	}
+	return nil
+}
+
+// attachCommentReactions fills in the reaction summaries of the comments for the viewer with one query.
+func attachCommentReactions(reactionRepo *repository.ReactionRepository, comments []model.Comment, viewerID int) error {
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.Id
+	}
+	summaries, err := reactionRepo.GetReactionSummaries(commentRefType, ids, viewerID)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Reactions = withCounts(summaries[comments[i].Id])
+	}
+	return nil
+}
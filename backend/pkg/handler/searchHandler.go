@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Search returns the best defaultSearchLimit matches of each type, or ?limit= up to maxSearchLimit.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+// searchTypes are the values of ?type=, the keys of model.SearchResults.
+var searchTypes = []string{"users", "posts", "groups", "events"}
+
+// SearchHandler searches users, posts, groups and events with full-text search.
+type SearchHandler struct {
+	searchRepo *repository.SearchRepository
+}
+
+func NewSearchHandler(searchRepo *repository.SearchRepository) *SearchHandler {
+	return &SearchHandler{searchRepo: searchRepo}
+}
+
+// GetSearchResultsHandler searches for the words of ?q= in all types, or only in ?type=.
+// Posts are limited to those the user can see.
+func (h *SearchHandler) GetSearchResultsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error confirming user authentication: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	query := repository.SearchQuery(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, it must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	resultType := r.URL.Query().Get("type")
+	if resultType != "" && !isSearchType(resultType) {
+		http.Error(w, fmt.Sprintf("Invalid type, it must be one of %v", searchTypes), http.StatusBadRequest)
+		return
+	}
+
+	results := model.SearchResults{
+		Users:  []model.SearchResult{},
+		Posts:  []model.SearchResult{},
+		Groups: []model.SearchResult{},
+		Events: []model.SearchResult{},
+	}
+	if resultType == "" || resultType == "users" {
+		results.Users, err = h.searchRepo.SearchUsers(query, userID, limit)
+	}
+	if err == nil && (resultType == "" || resultType == "posts") {
+		results.Posts, err = h.searchRepo.SearchPosts(query, userID, limit)
+	}
+	if err == nil && (resultType == "" || resultType == "groups") {
+		results.Groups, err = h.searchRepo.SearchGroups(query, limit)
+	}
+	if err == nil && (resultType == "" || resultType == "events") {
+		results.Events, err = h.searchRepo.SearchEvents(query, limit)
+	}
+	if err != nil {
+		http.Error(w, "Failed to search: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func isSearchType(resultType string) bool {
+	for _, searchType := range searchTypes {
+		if resultType == searchType {
+			return true
+		}
+	}
+	return false
+}
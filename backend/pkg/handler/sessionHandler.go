@@ -1,19 +1,28 @@
 package handler
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/model"
+	"backend/pkg/ratelimit"
+	"backend/pkg/repository"
+	"backend/pkg/totp"
 	"backend/util"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // LoginHandler handles the login request.
 // It decodes the login data from the request body and validates the user's credentials.
+// Failed logins are rate limited per account and per client IP; a blocked client gets a 429 with a Retry-After header.
+// Every attempt is written to the login_attempts audit table.
 // If the credentials are valid, it generates a session token, stores it in the database, and sets a cookie with the session token.
 // Finally, it sends a success response indicating that the login was successful.
 func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,34 +34,90 @@ func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error parsing login JSON data: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	attempt := model.LoginAttempt{Username: logData.Username, IPAddress: util.GetClientIP(r), UserAgent: r.UserAgent()}
 	user, err := h.userRepo.GetUserByEmailOrNickname(logData.Username)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Existing users are limited by ID so logging in with the email or the username counts the same
+	accountKey := ratelimit.AccountKey(logData.Username)
+	if err == nil {
+		attempt.UserID = user.Id
+		accountKey = ratelimit.UserKey(user.Id)
+	}
+	ipKey := ratelimit.IPKey(attempt.IPAddress)
+
+	retryAfter, err := h.loginLimiter.Check(accountKey, ipKey)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		response := map[string]string{"error": "Incorrect login credentials."}
-		json.NewEncoder(w).Encode(response)
+		http.Error(w, "Error checking login rate limit: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		attempt.Reason = "rate_limited"
+		h.recordLoginAttempt(attempt)
+		writeTooManyAttempts(w, retryAfter)
 		return
 	}
 
-	// Compare hashed password with provided password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(logData.Password))
-	if err != nil { // Wrong password
+	if attempt.UserID == 0 {
+		attempt.Reason = "unknown_user"
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(logData.Password)) != nil { // Compare hashed password with provided password
+		attempt.Reason = "wrong_password"
+	}
+	if attempt.Reason != "" {
+		h.recordLoginAttempt(attempt)
+		if err := h.loginLimiter.RegisterFailure(accountKey, ipKey); err != nil {
+			fmt.Println("Error registering failed login: ", err)
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		response := map[string]string{"error": "Incorrect login credentials."}
 		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	// Generate a session token and store it in database
-	sessionToken := util.GenerateSessionToken()
-	h.sessionRepo.StoreSessionInDB(sessionToken, user.Id)
+	// With 2FA the password only gets a challenge; the session is created by LoginTwoFactorHandler
+	twoFactorEnabled, err := h.twoFactorRepo.IsEnabled(user.Id)
+	if err != nil {
+		http.Error(w, "Error checking two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		mfaToken := util.GenerateSessionToken()
+		err = h.twoFactorRepo.CreateChallenge(util.HashToken(mfaToken), model.MFAChallenge{
+			UserID:     user.Id,
+			RememberMe: logData.RememberMe,
+			ExpiresAt:  time.Now().Add(h.twoFactorConfig.ChallengeTTL),
+		})
+		if err != nil {
+			http.Error(w, "Error creating MFA challenge: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attempt.Reason = "mfa_required"
+		h.recordLoginAttempt(attempt)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":      "Enter the code from your authenticator app",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	attempt.Success = true
+	attempt.Reason = "success"
+	h.recordLoginAttempt(attempt)
+	if err := h.loginLimiter.RegisterSuccess(accountKey); err != nil {
+		fmt.Println("Error resetting login failures: ", err)
+	}
 
-	// Set a cookie with the session
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session_token",
-		Value:  sessionToken,
-		MaxAge: 60 * 30, // 30 minutes
-		Path:   "/",     // Make cookie available for all paths
-	})
+	if err := h.startSession(w, r, user.Id, logData.RememberMe); err != nil {
+		http.Error(w, "Error creating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Send a success response
 	w.Header().Set("Content-Type", "application/json")
@@ -61,22 +126,172 @@ func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// LogoutHandler handles the logout functionality by deleting the session-token cookie and sending a success response.
+// LoginTwoFactorHandler is the second step of the login of users with 2FA.
+// It takes the mfa_token returned by LoginHandler and either a code from the authenticator app or an unused recovery code.
+// A challenge allows maxMFAAttempts wrong codes, and wrong codes count as failed logins for the rate limiter.
+func (h *UserHandler) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.twoFactorRepo.GetChallenge(util.HashToken(request.MFAToken))
+	if err == sql.ErrNoRows {
+		middleware.WriteUnauthorized(w, "Login has expired, please log in again")
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting MFA challenge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attempt := model.LoginAttempt{UserID: challenge.UserID, IPAddress: util.GetClientIP(r), UserAgent: r.UserAgent()}
+	accountKey := ratelimit.UserKey(challenge.UserID)
+	ipKey := ratelimit.IPKey(attempt.IPAddress)
+	retryAfter, err := h.loginLimiter.Check(accountKey, ipKey)
+	if err != nil {
+		http.Error(w, "Error checking login rate limit: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		attempt.Reason = "rate_limited"
+		h.recordLoginAttempt(attempt)
+		writeTooManyAttempts(w, retryAfter)
+		return
+	}
+
+	valid, err := h.checkSecondFactor(challenge.UserID, request)
+	if err != nil {
+		http.Error(w, "Error checking code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		attempt.Reason = "wrong_mfa_code"
+		h.recordLoginAttempt(attempt)
+		if err := h.loginLimiter.RegisterFailure(accountKey, ipKey); err != nil {
+			fmt.Println("Error registering failed login: ", err)
+		}
+		// After too many wrong codes the password has to be entered again
+		if challenge.Attempts+1 >= maxMFAAttempts {
+			err = h.twoFactorRepo.DeleteChallenge(challenge.Id)
+		} else {
+			err = h.twoFactorRepo.IncrementChallengeAttempts(challenge.Id)
+		}
+		if err != nil {
+			fmt.Println("Error updating MFA challenge: ", err)
+		}
+		middleware.WriteUnauthorized(w, "Invalid two-factor code")
+		return
+	}
+
+	if err := h.twoFactorRepo.DeleteChallenge(challenge.Id); err != nil {
+		http.Error(w, "Error deleting MFA challenge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	attempt.Success = true
+	attempt.Reason = "success"
+	h.recordLoginAttempt(attempt)
+	if err := h.loginLimiter.RegisterSuccess(accountKey); err != nil {
+		fmt.Println("Error resetting login failures: ", err)
+	}
+
+	if err := h.startSession(w, r, challenge.UserID, challenge.RememberMe); err != nil {
+		http.Error(w, "Error creating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Login successful",
+	})
+}
+
+// checkSecondFactor validates the TOTP code or recovery code of the request. Both can only be used once.
+func (h *UserHandler) checkSecondFactor(userID int, request model.LoginTwoFactorRequest) (bool, error) {
+	if request.RecoveryCode != "" {
+		return h.twoFactorRepo.UseRecoveryCode(userID, util.HashToken(normalizeRecoveryCode(request.RecoveryCode)))
+	}
+
+	secret, err := h.twoFactorRepo.GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret.Secret, request.Code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return h.twoFactorRepo.UseTOTPStep(userID, step)
+}
+
+// startSession generates a session token, stores the session in database and sets the session cookie.
+func (h *UserHandler) startSession(w http.ResponseWriter, r *http.Request, userID int, rememberMe bool) error {
+	session, err := h.sessionRepo.StoreSessionInDB(model.Session{
+		SessionToken: util.GenerateSessionToken(),
+		UserID:       userID,
+		UserAgent:    r.UserAgent(),
+		IPAddress:    util.GetClientIP(r),
+		RememberMe:   rememberMe,
+	})
+	if err != nil {
+		return err
+	}
+	h.setSessionCookie(w, session)
+	return nil
+}
+
+// writeTooManyAttempts sends a 429 response with a Retry-After header to a client blocked by the login rate limiter.
+func writeTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Too many failed login attempts. Try again later."})
+}
+
+// recordLoginAttempt writes the attempt to the audit table. Errors are only logged so auditing never blocks a login.
+func (h *UserHandler) recordLoginAttempt(attempt model.LoginAttempt) {
+	if err := h.loginAttemptRepo.RecordLoginAttempt(attempt); err != nil {
+		fmt.Println("Error recording login attempt: ", err)
+	}
+}
+
+// setSessionCookie sets the session_token cookie for a newly created session.
+// The cookie is HttpOnly, and Secure and SameSite depend on the environment (see config.CookieConfig).
+// "Remember me" sessions get a persistent cookie that lives until the absolute expiry of the session,
+// other sessions get a browser-session cookie. The expiry itself is enforced on the server and slides with activity.
+func (h *UserHandler) setSessionCookie(w http.ResponseWriter, session model.Session) {
+	cookie := h.cookieConfig.NewCookie("session_token", session.SessionToken, true)
+	if session.RememberMe {
+		cookie.MaxAge = int(time.Until(session.AbsoluteExpiresAt).Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// LogoutHandler handles the logout functionality by deleting the session from the database,
+// closing the websocket connections opened with it, deleting the session-token cookie and sending a success response.
 // If the session-token cookie is not found or there is an error, it returns a bad request error.
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := r.Cookie("session_token")
+func (h *UserHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_token")
 	if err != nil && err != http.ErrNoCookie {
 		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Delete the session-token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   "",
-		MaxAge:  -1, // Setting MaxAge to -1 immediately expires the cookie
-		Expires: time.Unix(0, 0),
-	})
+	if cookie != nil && cookie.Value != "" {
+		// Invalidate the session on the server so a copied token stops working
+		err = h.sessionRepo.DeleteSession(cookie.Value)
+		if err != nil {
+			http.Error(w, "Error deleting session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.hub.CloseSessionConnections(cookie.Value)
+	}
+
+	// Delete the session-token cookie, with the same attributes it was set with
+	cookie = h.cookieConfig.NewCookie("session_token", "", true)
+	cookie.MaxAge = -1 // Setting MaxAge to -1 immediately expires the cookie
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, cookie)
 
 	// Send a success reponse
 	w.Header().Set("Content-Type", "application/json")
@@ -102,25 +317,31 @@ func (h *UserHandler) CheckAuth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	emailVerified := false
 	if isAuthenticated {
 		sessionToken := cookie.Value
 
-		// Get the session from database by the session token
-		session, err := h.sessionRepo.GetSessionBySessionToken(sessionToken)
+		// Get the session from database by the session token and check that it hasn't expired
+		session, err := h.sessionRepo.ValidateSession(sessionToken)
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if err == sql.ErrNoRows || err == repository.ErrSessionExpired {
 				isAuthenticated = false
 			} else {
 				fmt.Println("Error getting session token from database: " + err.Error())
+				http.Error(w, "Error checking session token: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			emailVerified, err = h.userRepo.IsEmailVerified(session.UserID)
+			if err != nil {
+				http.Error(w, "Error checking email verification: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-		}
-		if time.Now().After(session.ExpiresAt) {
-			isAuthenticated = false
 		}
 	}
 	response := model.AuthResponse{
 		IsAuthenticated: isAuthenticated,
+		EmailVerified:   emailVerified,
 	}
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
@@ -131,3 +352,80 @@ func (h *UserHandler) CheckAuth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResponse)
 }
+
+// GetSessionsHandler lists the active sessions of the logged in user, one for every device they are logged in on.
+// The session the request was made with is marked as current.
+func (h *UserHandler) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionRepo.GetSessionsByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error getting sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentToken := util.GetSessionToken(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionToken == currentToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSessionHandler logs the user out on another device by deleting the session with the ID from the URL.
+// Only sessions of the logged in user can be revoked.
+func (h *UserHandler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionToken, err := h.sessionRepo.DeleteUserSession(sessionID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error revoking session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseSessionConnections(sessionToken)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Session revoked",
+	})
+}
+
+// RevokeOtherSessionsHandler logs the user out everywhere except on the device the request was made from.
+func (h *UserHandler) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	revokedTokens, err := h.sessionRepo.DeleteOtherUserSessions(userID, util.GetSessionToken(r))
+	if err != nil {
+		http.Error(w, "Error revoking sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseSessionConnections(revokedTokens...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Other sessions revoked",
+		"revoked": len(revokedTokens),
+	})
+}
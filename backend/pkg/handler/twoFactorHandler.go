@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"backend/pkg/middleware"
+	"backend/pkg/model"
+	"backend/pkg/ratelimit"
+	"backend/pkg/repository"
+	"backend/pkg/totp"
+	"backend/util"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxMFAAttempts is how many wrong codes a login challenge allows before the password has to be entered again
+	maxMFAAttempts = 5
+	// recoveryCodeCount is how many recovery codes are generated when 2FA is enabled
+	recoveryCodeCount = 10
+)
+
+type TwoFactorHandler struct {
+	userRepo      *repository.UserRepository
+	twoFactorRepo *repository.TwoFactorRepository
+	loginLimiter  *ratelimit.LoginLimiter
+	issuer        string
+}
+
+func NewTwoFactorHandler(uRepo *repository.UserRepository, tfRepo *repository.TwoFactorRepository, loginLimiter *ratelimit.LoginLimiter, issuer string) *TwoFactorHandler {
+	return &TwoFactorHandler{userRepo: uRepo, twoFactorRepo: tfRepo, loginLimiter: loginLimiter, issuer: issuer}
+}
+
+// GetTwoFactorStatusHandler tells whether the logged in user has 2FA on and how many recovery codes are left.
+func (h *TwoFactorHandler) GetTwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var status model.TwoFactorStatus
+	status.Enabled, err = h.twoFactorRepo.IsEnabled(userID)
+	if err != nil {
+		http.Error(w, "Error checking two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status.Enabled {
+		status.RecoveryCodesLeft, err = h.twoFactorRepo.CountRecoveryCodes(userID)
+		if err != nil {
+			http.Error(w, "Error counting recovery codes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SetupTwoFactorHandler generates a new TOTP secret for the logged in user and returns it with its otpauth:// URI.
+// 2FA stays off until the user confirms a code from their authenticator app with EnableTwoFactorHandler.
+func (h *TwoFactorHandler) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	enabled, err := h.twoFactorRepo.IsEnabled(userID)
+	if err != nil {
+		http.Error(w, "Error checking two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.twoFactorRepo.SavePendingSecret(userID, secret); err != nil {
+		http.Error(w, "Error saving secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(h.issuer, user.Email, secret),
+	})
+}
+
+// EnableTwoFactorHandler turns 2FA on once the user proves their authenticator app works by sending a valid code.
+// It responds with the recovery codes, which are only stored hashed and can't be shown again.
+func (h *TwoFactorHandler) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := h.twoFactorRepo.GetTOTP(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor authentication has not been set up", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error getting secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !secret.EnabledAt.IsZero() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(secret.Secret, request.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.twoFactorRepo.EnableTOTP(userID, step, hashes); err != nil {
+		http.Error(w, "Error enabling two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactorHandler turns 2FA off. It requires the current password, so a hijacked session can't do it.
+// Wrong passwords count as failed logins for the rate limiter.
+func (h *TwoFactorHandler) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PasswordConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPassword(w, r, h.userRepo, h.loginLimiter, userID, request.Password) {
+		return
+	}
+
+	if err := h.twoFactorRepo.DeleteTOTP(userID); err != nil {
+		http.Error(w, "Error disabling two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// checkPassword compares the password with the one of the logged in user, rate limited like logins.
+// If the password is wrong, the user is blocked, has no password or there is an error, it writes the response
+// and returns false.
+func checkPassword(w http.ResponseWriter, r *http.Request, userRepo *repository.UserRepository, loginLimiter *ratelimit.LoginLimiter, userID int, password string) bool {
+	accountKey := ratelimit.UserKey(userID)
+	ipKey := ratelimit.IPKey(util.GetClientIP(r))
+	retryAfter, err := loginLimiter.Check(accountKey, ipKey)
+	if err != nil {
+		http.Error(w, "Error checking rate limit: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if retryAfter > 0 {
+		writeTooManyAttempts(w, retryAfter)
+		return false
+	}
+
+	user, err := userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	// Users who registered with a login provider have no password to confirm until they set one
+	if user.Password == "" {
+		http.Error(w, "Set a password first with POST /api/users/password", http.StatusConflict)
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		if err := loginLimiter.RegisterFailure(accountKey, ipKey); err != nil {
+			fmt.Println("Error registering failed password check: ", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Incorrect password"})
+		return false
+	}
+	return true
+}
+
+// generateRecoveryCodes returns new recovery codes like "k3j9x-7fq2m" and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = util.HashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode makes recovery codes case-insensitive and ignores dashes and spaces.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
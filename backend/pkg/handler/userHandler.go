@@ -1,25 +1,43 @@
 package handler
 
 import (
+	"backend/pkg/config"
+	"backend/pkg/media"
+	"backend/pkg/middleware"
 	"backend/pkg/model"
+	"backend/pkg/ratelimit"
 	"backend/pkg/repository"
+	"backend/pkg/ws"
 	"backend/util"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/mail"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	userRepo    *repository.UserRepository
-	sessionRepo *repository.SessionRepository
-	friendsRepo *repository.FriendsRepository
+	userRepo         *repository.UserRepository
+	sessionRepo      *repository.SessionRepository
+	apiTokenRepo     *repository.APITokenRepository
+	friendsRepo      *repository.FriendsRepository
+	loginAttemptRepo *repository.LoginAttemptRepository
+	twoFactorRepo    *repository.TwoFactorRepository
+	loginLimiter     *ratelimit.LoginLimiter
+	emailVerifier    *EmailVerificationHandler
+	hub              *ws.Hub
+	cookieConfig     config.CookieConfig
+	twoFactorConfig  config.TwoFactorConfig
+	passwordPolicy   config.PasswordPolicyConfig
+	mediaUploader    *MediaUploader
 }
 
-func NewUserHandler(uRepo *repository.UserRepository, sRepo *repository.SessionRepository, fRepo *repository.FriendsRepository) *UserHandler {
-	return &UserHandler{userRepo: uRepo, sessionRepo: sRepo, friendsRepo: fRepo}
+func NewUserHandler(uRepo *repository.UserRepository, sRepo *repository.SessionRepository, atRepo *repository.APITokenRepository, fRepo *repository.FriendsRepository, laRepo *repository.LoginAttemptRepository, tfRepo *repository.TwoFactorRepository, loginLimiter *ratelimit.LoginLimiter, emailVerifier *EmailVerificationHandler, hub *ws.Hub, cookieConfig config.CookieConfig, twoFactorConfig config.TwoFactorConfig, passwordPolicy config.PasswordPolicyConfig, mediaUploader *MediaUploader) *UserHandler {
+	return &UserHandler{mediaUploader: mediaUploader, userRepo: uRepo, sessionRepo: sRepo, apiTokenRepo: atRepo, friendsRepo: fRepo, loginAttemptRepo: laRepo, twoFactorRepo: tfRepo, loginLimiter: loginLimiter, emailVerifier: emailVerifier, hub: hub, cookieConfig: cookieConfig, twoFactorConfig: twoFactorConfig, passwordPolicy: passwordPolicy}
 }
 
 func (h *UserHandler) UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +57,11 @@ func (h *UserHandler) UserRegisterHandler(w http.ResponseWriter, r *http.Request
 	regData.DOB = r.FormValue("dob")
 	regData.About = r.FormValue("about")
 
+	if err := validatePassword(h.passwordPolicy, regData.Password, regData.Username, regData.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(regData.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -48,25 +71,34 @@ func (h *UserHandler) UserRegisterHandler(w http.ResponseWriter, r *http.Request
 	// Change input password data to hashed variant
 	regData.Password = string(hashedPassword)
 
-	util.ImageSave(w, r, &regData) // parses image data from request to the variable
+	// The avatar is checked before the user is created, so a bad upload can be fixed and sent again
+	avatar, err := h.mediaUploader.process(r, "avatar")
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
 	// Store user in database
 	userID, err := h.userRepo.RegisterUser(regData)
 	if err != nil {
 		http.Error(w, "Error registering user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if avatar != nil {
+		if err := h.updateProfile(int(userID), model.ProfileUpdate{}, avatar); err != nil {
+			fmt.Println("Error saving avatar: ", err)
+		}
+	}
 
-	// Generate a session token and store it in database with expiration time
-	sessionToken := util.GenerateSessionToken()
-	h.sessionRepo.StoreSessionInDB(sessionToken, int(userID))
+	// The account can be used right away, but restricted until the email address is confirmed
+	if err := h.emailVerifier.sendVerification(int(userID), regData.Email, regData.FirstName); err != nil {
+		fmt.Println("Error sending verification email: ", err)
+	}
 
-	// Set a cookie with the session token
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session_token",
-		Value:  sessionToken,
-		MaxAge: 60 * 30, // 30 minutes
-		Path:   "/",     // Make cookie available for all paths
-	})
+	// Generate a session token, store it in database with expiration time and set the session cookie
+	if err := h.startSession(w, r, int(userID), false); err != nil {
+		http.Error(w, "Error creating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Send a success response
 	response := map[string]interface{}{
@@ -78,9 +110,9 @@ func (h *UserHandler) UserRegisterHandler(w http.ResponseWriter, r *http.Request
 
 func (h *UserHandler) GetUserProfileByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the user ID from the URL
-	requestUserID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	requestUserID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Invalid user ID: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Invalid user ID: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 	vars := mux.Vars(r)
@@ -134,61 +166,221 @@ func (h *UserHandler) GetUserProfileByIDHandler(w http.ResponseWriter, r *http.R
 	json.NewEncoder(w).Encode(profile)
 }
 
+// EditUserProfileHandler updates the profile of the logged in user with PATCH semantics:
+// only the fields present in the request are changed, omitted fields keep their value.
+// It takes multipart form data (needed to upload a new avatar) or JSON. Passwords are changed with ChangePasswordHandler.
 func (h *UserHandler) EditUserProfileHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. Parse the multipart form data from the request
-	err1 := r.ParseMultipartForm(10 << 20) // Maximum memory 10MB, change this based on your requirements
-	if err1 != nil {
-		http.Error(w, "Error parsing form data: "+err1.Error(), http.StatusBadRequest)
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "Error getting user id: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// Users can only edit their own profile
+	if id := mux.Vars(r)["id"]; id != "me" && id != strconv.Itoa(userID) {
+		http.Error(w, "You can only edit your own profile", http.StatusForbidden)
 		return
 	}
-	var regData model.RegistrationData // variable based on struct fields for storing registration data
-
-	regData.Username = r.FormValue("username")
-	regData.Email = r.FormValue("email")
-	regData.Password = r.FormValue("password")
-	regData.FirstName = r.FormValue("first_name")
-	regData.LastName = r.FormValue("last_name")
-	regData.DOB = r.FormValue("dob")
-	regData.About = r.FormValue("about")
-	regData.ProfileSetting = r.FormValue("profile_setting")
 
-	// get userid from cookie
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	user, err := h.userRepo.GetUserByID(userID)
 	if err != nil {
-		http.Error(w, "Error getting user id: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(regData.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+	var update model.ProfileUpdate
+	var avatar *media.Image
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var fields map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			http.Error(w, "Error parsing JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := fields["password"]; ok {
+			http.Error(w, "Use /api/users/password to change the password", http.StatusBadRequest)
+			return
+		}
+		raw, _ := json.Marshal(fields)
+		if err := json.Unmarshal(raw, &update); err != nil {
+			http.Error(w, "Error parsing JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // Maximum memory 10MB
+			http.Error(w, "Error parsing form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		values := r.MultipartForm.Value
+		if _, ok := values["password"]; ok {
+			http.Error(w, "Use /api/users/password to change the password", http.StatusBadRequest)
+			return
+		}
+		update = model.ProfileUpdate{
+			Username:       formField(values, "username"),
+			Email:          formField(values, "email"),
+			FirstName:      formField(values, "first_name"),
+			LastName:       formField(values, "last_name"),
+			DOB:            formField(values, "dob"),
+			About:          formField(values, "about"),
+			ProfileSetting: formField(values, "profile_setting"),
+		}
+
+		// The avatar is only saved once the rest of the update is valid
+		avatar, err = h.mediaUploader.process(r, "avatar")
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+	}
+
+	if message := validateProfileUpdate(update); message != "" {
+		http.Error(w, message, http.StatusBadRequest)
 		return
 	}
-	// Change input password data to hashed variant
-	regData.Password = string(hashedPassword)
 
-	util.ImageSave(w, r, &regData) // parses image data from request to the variable
-	// Store user in database
-	err = h.userRepo.UpdateUserProfile(userID, regData)
+	err = h.updateProfile(userID, update, avatar)
+	if err == repository.ErrDuplicateUser {
+		http.Error(w, "Username or email is already taken", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error updating user profile: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// A new email address has to be confirmed again
+	if update.Email != nil && *update.Email != user.Email {
+		firstName := user.FirstName
+		if update.FirstName != nil {
+			firstName = *update.FirstName
+		}
+		if err := h.emailVerifier.sendVerification(userID, *update.Email, firstName); err != nil {
+			fmt.Println("Error sending verification email: ", err)
+		}
+	}
+
+	profile, err := h.userRepo.GetUserProfileByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user profile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Send a success response
 	response := map[string]interface{}{
 		"message": "User profile updated",
+		"profile": profile,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// formField returns a pointer to the form value, or nil if the field is not in the form.
+func formField(values map[string][]string, key string) *string {
+	if v, ok := values[key]; ok && len(v) > 0 {
+		return &v[0]
+	}
+	return nil
+}
+
+// validateProfileUpdate returns an error message for the first invalid field of the update, or "" if all are valid.
+func validateProfileUpdate(update model.ProfileUpdate) string {
+	required := []struct {
+		name  string
+		value *string
+	}{
+		{"Username", update.Username},
+		{"Email", update.Email},
+		{"First name", update.FirstName},
+		{"Last name", update.LastName},
+	}
+	for _, field := range required {
+		if field.value != nil && strings.TrimSpace(*field.value) == "" {
+			return field.name + " can't be empty"
+		}
+	}
+	if update.Email != nil {
+		if address, err := mail.ParseAddress(*update.Email); err != nil || address.Address != *update.Email {
+			return "Invalid email address"
+		}
+	}
+	if update.ProfileSetting != nil && *update.ProfileSetting != "public" && *update.ProfileSetting != "private" {
+		return "Profile setting must be public or private"
+	}
+	return ""
+}
+
+// ChangePasswordHandler changes the password of the logged in user.
+// It requires the current password (rate limited like logins), checks the new one against the password policy
+// and logs out all other sessions of the user and revokes their API tokens. Users who registered with a login provider and have no password
+// yet set their first one without a current password.
+func (h *UserHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.GetUserID(r)
+	if err != nil {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Error getting user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hadPassword := user.Password != ""
+	if hadPassword && !checkPassword(w, r, h.userRepo, h.loginLimiter, userID, request.CurrentPassword) {
+		return
+	}
+	if err := validatePassword(h.passwordPolicy, request.NewPassword, user.Username, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hadPassword && request.NewPassword == request.CurrentPassword {
+		http.Error(w, "New password must be different from the current one", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+	if err := h.userRepo.UpdatePassword(userID, string(hashedPassword)); err != nil {
+		http.Error(w, "Error updating password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the session the password was changed with, log out everywhere else
+	revokedTokens, err := h.sessionRepo.DeleteOtherUserSessions(userID, util.GetSessionToken(r))
+	if err != nil {
+		http.Error(w, "Error revoking sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseSessionConnections(revokedTokens...)
+	// API tokens could have been created by whoever knew the old password too
+	revokedAPITokens, err := h.apiTokenRepo.DeleteUserTokens(userID)
+	if err != nil {
+		http.Error(w, "Error revoking API tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseAPITokenConnections(revokedAPITokens...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":            "Password changed",
+		"revoked":            len(revokedTokens),
+		"revoked_api_tokens": len(revokedAPITokens),
+	})
+}
+
 func (h *UserHandler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	// get userid from cookie
-	userID, err := h.sessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
+	userID, err := middleware.GetUserID(r)
 	if err != nil {
-		http.Error(w, "Error getting user id: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error getting user id: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
@@ -200,3 +392,27 @@ func (h *UserHandler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
+
+// updateProfile updates the user's profile and, if an avatar was uploaded, replaces the avatar. The new avatar is
+// saved first and removed again if the update fails; the old one is only released once the profile no longer
+// points to it.
+func (h *UserHandler) updateProfile(userID int, update model.ProfileUpdate, avatar *media.Image) error {
+	if avatar == nil {
+		return h.userRepo.UpdateUserProfile(userID, update)
+	}
+	m, err := h.mediaUploader.save(avatar, userID)
+	if err != nil {
+		return err
+	}
+	update.AvatarURL = &m.URL
+	if err := h.userRepo.UpdateUserProfile(userID, update); err != nil {
+		if err := h.mediaUploader.discard([]model.Media{m}); err != nil {
+			fmt.Println("Error removing unused avatar: ", err)
+		}
+		return err
+	}
+	if err := h.mediaUploader.release(avatarRefType, userID); err != nil {
+		return err
+	}
+	return h.mediaUploader.link([]model.Media{m}, avatarRefType, userID)
+}
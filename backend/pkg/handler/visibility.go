@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"database/sql"
+)
+
+// Visibility decides which users can see a post or the private parts of a profile, and who moderates comments.
+// Everything that shows posts or what belongs to them (comments, images) should ask it.
+// A viewer ID of 0 is an anonymous user.
+type Visibility struct {
+	friendsRepo     *repository.FriendsRepository
+	groupMemberRepo *repository.GroupMemberRepository
+	postRepo        *repository.PostRepository
+}
+
+func NewVisibility(fRepo *repository.FriendsRepository, gmRepo *repository.GroupMemberRepository, pRepo *repository.PostRepository) *Visibility {
+	return &Visibility{friendsRepo: fRepo, groupMemberRepo: gmRepo, postRepo: pRepo}
+}
+
+// CanViewPost: authors see their posts, group posts are for the group's members and owner, and otherwise public posts are for
+// everyone, private posts for the author's friends and custom posts for the users the author picked.
+func (v *Visibility) CanViewPost(viewerID int, post model.Post) (bool, error) {
+	if viewerID != 0 && viewerID == post.UserID {
+		return true, nil
+	}
+	if post.GroupID != 0 {
+		return v.inGroup(viewerID, post.GroupID)
+	}
+	switch post.PrivacySetting {
+	case "public":
+		return true, nil
+	case "private":
+		return v.areFriends(viewerID, post.UserID)
+	case "custom":
+		if viewerID == 0 {
+			return false, nil
+		}
+		return v.postRepo.IsInPostAudience(post.Id, viewerID)
+	default:
+		return false, nil
+	}
+}
+
+// CanViewPostRevision reports whether the viewer could see an earlier version of a post they can see now:
+// authors see all of them, others only the versions whose own privacy setting would have let them see the post.
+// The audience of a custom version isn't kept when it's edited, so earlier custom versions are only for the author.
+func (v *Visibility) CanViewPostRevision(viewerID int, post model.Post, revision model.PostRevision) (bool, error) {
+	if viewerID != 0 && viewerID == post.UserID {
+		return true, nil
+	}
+	if revision.PrivacySetting == "custom" {
+		return false, nil
+	}
+	version := post
+	version.PrivacySetting = revision.PrivacySetting
+	return v.CanViewPost(viewerID, version)
+}
+
+// CanViewProfile reports whether the viewer can see what a private profile hides, like the avatar.
+func (v *Visibility) CanViewProfile(viewerID int, user model.User) (bool, error) {
+	if user.Profile != "private" || (viewerID != 0 && viewerID == user.Id) {
+		return true, nil
+	}
+	return v.areFriends(viewerID, user.Id)
+}
+
+// CanModerateComments reports whether the user moderates the comments of the post: its author does, and for
+// group posts the group's owner too.
+func (v *Visibility) CanModerateComments(userID int, post model.Post) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	if userID == post.UserID {
+		return true, nil
+	}
+	if post.GroupID == 0 {
+		return false, nil
+	}
+	isOwner, err := v.groupMemberRepo.IsUserGroupOwner(userID, post.GroupID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isOwner, err
+}
+
+// CanPostInGroup reports whether the user can post in the group: its members and its owner can.
+func (v *Visibility) CanPostInGroup(userID, groupID int) (bool, error) {
+	return v.inGroup(userID, groupID)
+}
+
+func (v *Visibility) areFriends(viewerID, userID int) (bool, error) {
+	if viewerID == 0 {
+		return false, nil
+	}
+	status, err := v.friendsRepo.GetFriendStatus(viewerID, userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return status == "accepted", err
+}
+
+// inGroup reports whether the user is a member or the owner of the group. Owners aren't in group_members.
+func (v *Visibility) inGroup(userID, groupID int) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	isMember, err := v.groupMemberRepo.IsUserGroupMember(userID, groupID)
+	if err == sql.ErrNoRows {
+		isMember, err = false, nil
+	}
+	if err != nil || isMember {
+		return isMember, err
+	}
+	isOwner, err := v.groupMemberRepo.IsUserGroupOwner(userID, groupID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isOwner, err
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"backend/pkg/model"
+	"backend/pkg/repository"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// groupFixture is a group with a post by one member and a comment on it by another. Its owner isn't in
+// group_members, groups never add their creator.
+type groupFixture struct {
+	*testServer
+	postRepo *repository.PostRepository
+	postID   int
+	comment  int
+}
+
+// newGroupFixture has the users "owner", "author" (of the post), "commenter", "member" (neither) and "outsider".
+func newGroupFixture(t *testing.T) *groupFixture {
+	t.Helper()
+	s := newTestServer(t)
+	s.addUser(t, "owner", "author", "commenter", "member", "outsider")
+	groupMemberRepo := repository.NewGroupMemberRepository(s.db)
+	postRepo := repository.NewPostRepository(s.db)
+	commentRepo := repository.NewCommentRepository(s.db)
+	reactionRepo := repository.NewReactionRepository(s.db)
+	friendsRepo := repository.NewFriendsRepository(s.db)
+	f := &groupFixture{testServer: s, postRepo: postRepo}
+
+	groupID, err := repository.NewGroupRepository(s.db).CreateGroup(model.Group{CreatorId: s.users["owner"], Title: "Group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"author", "commenter", "member"} {
+		if err := groupMemberRepo.AddMemberToGroup(int(groupID), s.users[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	postID, err := postRepo.CreatePost(model.CreatePostRequest{Title: "Post", Content: "In the group", GroupID: int(groupID), PrivacySetting: "public"}, s.users["author"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.postID = int(postID)
+	commentID, err := commentRepo.CreateComment(model.Comment{PostID: f.postID, UserID: s.users["commenter"], Content: "A comment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.comment = int(commentID)
+
+	mediaUploader := s.mediaUploader()
+	visibility := NewVisibility(friendsRepo, groupMemberRepo, postRepo)
+	postHandler := NewPostHandler(postRepo, friendsRepo, groupMemberRepo, commentRepo, reactionRepo, mediaUploader, visibility)
+	commentHandler := NewCommentHandler(commentRepo, reactionRepo, postRepo, repository.NewUserRepository(s.db), repository.NewNotificationRepository(s.db), mediaUploader, visibility, 3)
+	s.router.HandleFunc("/post/{id}", postHandler.GetPostByIDHandler).Methods("GET")
+	s.router.HandleFunc("/post/comment/{id}", commentHandler.DeleteCommentHandler).Methods("DELETE")
+	s.router.HandleFunc("/post/comment/{id}/revisions", commentHandler.GetCommentRevisionsHandler).Methods("GET")
+	return f
+}
+
+func TestGroupPostVisibility(t *testing.T) {
+	f := newGroupFixture(t)
+	tests := []struct {
+		user       string
+		wantStatus int
+		wantInFeed bool
+	}{
+		{"author", http.StatusOK, true},
+		{"member", http.StatusOK, true},
+		{"owner", http.StatusOK, true},
+		{"outsider", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			if w := f.do(http.MethodGet, "/post/"+strconv.Itoa(f.postID), tt.user); w.Code != tt.wantStatus {
+				t.Errorf("GET post = %d, want %d", w.Code, tt.wantStatus)
+			}
+			feed, err := f.postRepo.GetFeed(f.users[tt.user], nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inFeed := len(feed) == 1 && feed[0].Id == f.postID
+			if inFeed != tt.wantInFeed || len(feed) > 1 {
+				t.Errorf("feed = %d posts, want the group post: %v", len(feed), tt.wantInFeed)
+			}
+		})
+	}
+}
+
+func TestGroupOwnerModeratesComments(t *testing.T) {
+	f := newGroupFixture(t)
+	comment := "/post/comment/" + strconv.Itoa(f.comment)
+
+	tests := []struct {
+		user          string
+		wantRevisions int
+		wantDelete    int
+	}{
+		{"outsider", http.StatusNotFound, http.StatusNotFound},
+		{"member", http.StatusForbidden, http.StatusForbidden},
+		{"owner", http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := f.do(http.MethodGet, comment+"/revisions", tt.user); w.Code != tt.wantRevisions {
+			t.Errorf("GET revisions as %s = %d, want %d", tt.user, w.Code, tt.wantRevisions)
+		}
+		if w := f.do(http.MethodDelete, comment, tt.user); w.Code != tt.wantDelete {
+			t.Errorf("DELETE comment as %s = %d, want %d", tt.user, w.Code, tt.wantDelete)
+		}
+	}
+	var count int
+	f.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE id = ?`, f.comment).Scan(&count)
+	if count != 0 {
+		t.Error("the comment was not deleted")
+	}
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer doesn't deliver messages. It writes each one to its own .eml file in dir,
+// or to the log if dir is empty, so links can be followed during local development and in tests.
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+// NewFileMailer creates a new instance of FileMailer.
+func NewFileMailer(from, dir string) *FileMailer {
+	return &FileMailer{from: from, dir: dir}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if !validHeader(msg.To) || !validHeader(msg.Subject) {
+		return errors.New("invalid mail header")
+	}
+	content := format(m.from, msg)
+	if m.dir == "" {
+		log.Printf("Mail to %s:\n%s\n", msg.To, content)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), content, 0o644)
+}
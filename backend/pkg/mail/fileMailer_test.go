@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"backend/pkg/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer := NewFileMailer("Social Network <noreply@example.com>", dir)
+	msg := Message{To: "bob@example.com", Subject: "Reset your password", Body: "Open this link:\nhttp://localhost:3000/reset?token=abc"}
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files = %v, %v, want one .eml file", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, want := range []string{
+		"From: Social Network <noreply@example.com>\r\n",
+		"To: bob@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"\r\n\r\nOpen this link:\r\nhttp://localhost:3000/reset?token=abc",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("message %q doesn't contain %q", content, want)
+		}
+	}
+
+	// Every message gets its own file
+	if err := mailer.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 2 {
+		t.Errorf("files = %v, want two .eml files", files)
+	}
+}
+
+func TestFileMailerHeaderInjection(t *testing.T) {
+	dir := t.TempDir()
+	mailer := NewFileMailer("noreply@example.com", dir)
+	tests := []Message{
+		{To: "bob@example.com\r\nBcc: eve@example.com", Subject: "Hello"},
+		{To: "bob@example.com", Subject: "Hello\nBcc: eve@example.com"},
+	}
+	for _, msg := range tests {
+		if err := mailer.Send(msg); err == nil {
+			t.Errorf("Send(%q, %q) succeeded", msg.To, msg.Subject)
+		}
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d messages were written, want none", len(files))
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{"smtp", "*mail.SMTPMailer"},
+		{"file", "*mail.FileMailer"},
+		{"", "*mail.FileMailer"},
+		{"unknown", "*mail.FileMailer"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf("%T", NewMailer(config.MailConfig{Driver: tt.driver})); got != tt.want {
+			t.Errorf("NewMailer(%q) = %s, want %s", tt.driver, got, tt.want)
+		}
+	}
+}
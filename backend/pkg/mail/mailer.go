@@ -0,0 +1,53 @@
+// Package mail sends the emails of the application (password reset links, ...).
+package mail
+
+import (
+	"backend/pkg/config"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages. SMTPMailer delivers them, FileMailer keeps them locally for development and tests.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer returns the Mailer selected by cfg.Driver.
+func NewMailer(cfg config.MailConfig) Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg)
+	default:
+		if cfg.Driver != "file" {
+			fmt.Printf("Unknown mail driver %q, using file\n", cfg.Driver)
+		}
+		return NewFileMailer(cfg.From, cfg.FileDir)
+	}
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader reports whether value can be used in a header without injecting other headers.
+func validHeader(value string) bool {
+	return !strings.ContainsAny(value, "\r\n")
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"backend/pkg/config"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends messages through an SMTP server. It authenticates with PLAIN auth if a username is set,
+// which net/smtp only allows over TLS (STARTTLS) or to localhost.
+type SMTPMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a new instance of SMTPMailer.
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{
+		addr:     cfg.SMTPHost + ":" + strconv.Itoa(cfg.SMTPPort),
+		host:     cfg.SMTPHost,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.From,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if !validHeader(msg.To) || !validHeader(msg.Subject) {
+		return errors.New("invalid mail header")
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid MAIL_FROM address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	return smtp.SendMail(m.addr, auth, from.Address, []string{to.Address}, format(m.from, msg))
+}
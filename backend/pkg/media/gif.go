@@ -0,0 +1,72 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errGIFStructure = errors.New("gif: malformed block structure")
+
+// gifFrames walks the blocks of a GIF without decoding the frames and returns how many frames it has and the sum
+// of their areas in pixels. gif.DecodeAll allocates every frame, so this is checked before decoding.
+// It stops counting once the area goes over maxArea.
+func gifFrames(data []byte, maxArea int) (frames, area int, err error) {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, errGIFStructure
+	}
+	i := 13 + colorTableSize(data[10])
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: label and sub-blocks
+			if i+2 > len(data) {
+				return 0, 0, errGIFStructure
+			}
+			if i, err = skipSubBlocks(data, i+2); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // Image descriptor, color table, LZW code size and sub-blocks of image data
+			if i+10 > len(data) {
+				return 0, 0, errGIFStructure
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5 : i+7]))
+			height := int(binary.LittleEndian.Uint16(data[i+7 : i+9]))
+			frames++
+			area += width * height
+			if area > maxArea {
+				return frames, area, nil
+			}
+			if i, err = skipSubBlocks(data, i+10+colorTableSize(data[i+9])+1); err != nil {
+				return 0, 0, err
+			}
+		case 0x3B: // Trailer
+			return frames, area, nil
+		default:
+			return 0, 0, errGIFStructure
+		}
+	}
+	return frames, area, nil
+}
+
+// colorTableSize is the size in bytes of the color table that follows a block with the given packed fields.
+func colorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << ((packed & 0x07) + 1)
+}
+
+// skipSubBlocks returns the position after the sub-blocks starting at i, which end with an empty one.
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errGIFStructure
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
@@ -0,0 +1,176 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned by LocalStore.VerifySignedURL for missing, wrong or expired signatures.
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+// LocalStore keeps blobs as files in a directory, which the server serves under baseURL (see Service.Serve).
+// Signed URLs carry an expiry and an HMAC of the name and expiry, made with signingKey.
+type LocalStore struct {
+	dir        string
+	baseURL    string
+	signingKey []byte
+}
+
+// NewLocalStore creates the store. Without a signing key a random one is used, so signed URLs stop working on restart.
+func NewLocalStore(dir, baseURL, signingKey string) *LocalStore {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		fmt.Println("MEDIA_SIGNING_KEY is not set, signed image URLs will be invalid after a restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &LocalStore{dir: dir, baseURL: baseURL, signingKey: key}
+}
+
+// path returns the file path of the blob. Names can't leave the directory.
+func (s *LocalStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+// Put writes the file through a temporary file, so a half-written image is never served.
+func (s *LocalStore) Put(name string, data []byte, contentType string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return fmt.Errorf("invalid blob name %q", name)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Open(name string) (*Blob, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, ErrNotFound
+	}
+	return &Blob{
+		Content:     file,
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		close:       file.Close,
+	}, nil
+}
+
+func (s *LocalStore) Exists(name string) (bool, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return false, nil
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the files in the directory, without temporary files and subdirectories.
+func (s *LocalStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *LocalStore) URL(name string) string {
+	return s.baseURL + "/" + url.PathEscape(name)
+}
+
+func (s *LocalStore) SignedURL(name string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return s.URL(name) + "?expires=" + expires + "&signature=" + s.signature(name, expires)
+}
+
+// VerifySignedURL checks the expires and signature query parameters of a URL made by SignedURL.
+func (s *LocalStore) VerifySignedURL(name string, query url.Values) error {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return ErrInvalidSignature
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(s.signature(name, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *LocalStore) signature(name, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,148 @@
+package media
+
+import (
+	"backend/pkg/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreVerifySignedURL(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://localhost:8080/images", "key")
+	valid := signedQuery(t, store.SignedURL("image.png", time.Minute))
+	expired := signedQuery(t, store.SignedURL("image.png", -time.Second))
+	otherKey := signedQuery(t, NewLocalStore(t.TempDir(), "http://localhost:8080/images", "other").SignedURL("image.png", time.Minute))
+
+	extended := signedQuery(t, store.SignedURL("image.png", time.Minute))
+	extended.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+
+	tests := []struct {
+		name    string
+		blob    string
+		query   url.Values
+		wantErr bool
+	}{
+		{"valid", "image.png", valid, false},
+		{"expired", "image.png", expired, true},
+		{"other blob", "other.png", valid, true},
+		{"other signing key", "image.png", otherKey, true},
+		{"extended expiry", "image.png", extended, true},
+		{"no signature", "image.png", url.Values{"expires": valid["expires"]}, true},
+		{"no expiry", "image.png", url.Values{"signature": valid["signature"]}, true},
+		{"not hex", "image.png", url.Values{"expires": valid["expires"], "signature": {"zz"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.VerifySignedURL(tt.blob, tt.query)
+			if tt.wantErr && err != ErrInvalidSignature {
+				t.Errorf("VerifySignedURL() error = %v, want ErrInvalidSignature", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("VerifySignedURL() error = %v", err)
+			}
+		})
+	}
+}
+
+// signedQuery returns the query of a signed URL.
+func signedQuery(t *testing.T, signedURL string) url.Values {
+	t.Helper()
+	parsed, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Query()
+}
+
+// TestLocalStoreSignedURLExpiry serves a restricted image like the /images route does for signed URLs.
+func TestLocalStoreSignedURLExpiry(t *testing.T) {
+	var service *Service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/images/")
+		if !service.VerifySignedURL(name, r.URL.Query()) {
+			http.NotFound(w, r)
+			return
+		}
+		service.Serve(w, r, name, false)
+	}))
+	defer server.Close()
+
+	store := NewLocalStore(t.TempDir(), server.URL+"/images", "key")
+	service = NewService(config.MediaConfig{SignedURLTTL: time.Minute}, store)
+	if err := store.Put("image.png", []byte("image data"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"signed", store.SignedURL("image.png", time.Minute), http.StatusOK},
+		{"expired", store.SignedURL("image.png", -time.Second), http.StatusNotFound},
+		{"unsigned", store.URL("image.png"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d", tt.url, resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && string(body) != "image data" {
+				t.Errorf("body = %q, want the image", body)
+			}
+		})
+	}
+}
+
+func TestLocalStoreBlobs(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://localhost:8080/images", "key")
+	if err := store.Put("image.png", []byte("image data"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists("image.png"); err != nil || !exists {
+		t.Errorf("Exists() = %v, %v, want true", exists, err)
+	}
+	blob, err := store.Open("image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob.Content)
+	blob.Close()
+	if string(data) != "image data" || blob.ContentType != "image/png" || blob.Size != int64(len(data)) {
+		t.Errorf("Open() = %q (%s, %d bytes)", data, blob.ContentType, blob.Size)
+	}
+	if names, err := store.List(); err != nil || len(names) != 1 || names[0] != "image.png" {
+		t.Errorf("List() = %v, %v, want [image.png]", names, err)
+	}
+
+	if err := store.Delete("image.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open("image.png"); err != ErrNotFound {
+		t.Errorf("Open() of a deleted blob error = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("image.png"); err != nil {
+		t.Errorf("Delete() of a deleted blob error = %v", err)
+	}
+
+	// Names can't leave the directory
+	for _, name := range []string{"../image.png", "dir/image.png", ".hidden", ""} {
+		if err := store.Put(name, []byte("x"), "image/png"); err == nil {
+			t.Errorf("Put(%q) succeeded", name)
+		}
+		if _, err := store.Open(name); err != ErrNotFound {
+			t.Errorf("Open(%q) error = %v, want ErrNotFound", name, err)
+		}
+	}
+}
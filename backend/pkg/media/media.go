@@ -0,0 +1,299 @@
+// Package media validates and processes uploaded images.
+// Uploads are sniffed and only JPEG, PNG, GIF and WebP are accepted. Every image is decoded and re-encoded,
+// which drops EXIF and other metadata, and thumbnails are generated for the configured sizes.
+// Files are named after the SHA-256 hash of their content, so the same image is only stored once.
+package media
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/model"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+)
+
+// jpegQuality is the quality images are re-encoded with.
+const jpegQuality = 85
+
+// maxGIFFrames limits animated GIFs, whose frames all have to be decoded. Together they are limited to MaxPixels.
+const maxGIFFrames = 300
+
+var (
+	ErrTooLarge        = errors.New("image is too large")
+	ErrUnsupportedType = errors.New("only JPEG, PNG, GIF and WebP images are allowed")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+	ErrInvalidImage    = errors.New("image is damaged or can't be read")
+)
+
+// allowedTypes maps the sniffed content types that are accepted to the format name of the image decoder.
+var allowedTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Service processes uploads and keeps the results in a BlobStore.
+type Service struct {
+	config config.MediaConfig
+	store  BlobStore
+}
+
+func NewService(cfg config.MediaConfig, store BlobStore) *Service {
+	return &Service{config: cfg, store: store}
+}
+
+// Image is a processed upload that is ready to be saved.
+type Image struct {
+	Hash        string
+	ContentType string
+	Width       int
+	Height      int
+	data        []byte
+	thumbnails  map[int][]byte
+}
+
+// FileName is the content-addressed name the image is stored under.
+func (img *Image) FileName() string {
+	return img.Hash + extension(img.ContentType)
+}
+
+// Size is the size of the re-encoded image in bytes.
+func (img *Image) Size() int64 {
+	return int64(len(img.data))
+}
+
+// ThumbnailSizes returns the sizes of the generated thumbnails.
+func (img *Image) ThumbnailSizes() []int {
+	sizes := []int{}
+	for size := range img.thumbnails {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Process reads an upload and validates it: at most MaxBytes, a whitelisted type (sniffed from the content,
+// not taken from the file name or header) and dimensions within the limits, which are checked before decoding.
+// The image is then re-encoded and thumbnails are generated. Nothing is written to disk yet.
+func (s *Service) Process(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.config.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.config.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	format, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	imageConfig, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decodedFormat != format {
+		return nil, ErrInvalidImage
+	}
+	if imageConfig.Width <= 0 || imageConfig.Height <= 0 ||
+		imageConfig.Width > s.config.MaxDimension || imageConfig.Height > s.config.MaxDimension ||
+		imageConfig.Width*imageConfig.Height > s.config.MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	var encoded bytes.Buffer
+	var still image.Image
+	switch format {
+	case "gif":
+		// MaxPixels is the budget of all frames together, a small file can hold many large frames
+		frames, area, err := gifFrames(data, s.config.MaxPixels)
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		if frames > maxGIFFrames || area > s.config.MaxPixels {
+			return nil, ErrTooManyPixels
+		}
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(animation.Image) == 0 {
+			return nil, ErrInvalidImage
+		}
+		// Re-encoding keeps the animation; only the first frame is used for thumbnails
+		if err := gif.EncodeAll(&encoded, animation); err != nil {
+			return nil, err
+		}
+		canvas := image.NewNRGBA(image.Rect(0, 0, imageConfig.Width, imageConfig.Height))
+		draw.Draw(canvas, animation.Image[0].Bounds(), animation.Image[0], animation.Image[0].Bounds().Min, draw.Over)
+		still = canvas
+		contentType = "image/gif"
+	default:
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrInvalidImage
+		}
+		if format == "jpeg" {
+			decoded = applyOrientation(decoded, jpegOrientation(data))
+		}
+		// There is no WebP encoder in the standard library; WebP becomes JPEG, or PNG if it has transparency
+		if format == "png" || (format == "webp" && !isOpaque(decoded)) {
+			contentType = "image/png"
+		} else {
+			contentType = "image/jpeg"
+		}
+		if err := encode(&encoded, decoded, contentType); err != nil {
+			return nil, err
+		}
+		still = decoded
+	}
+
+	bounds := still.Bounds()
+	img := &Image{
+		ContentType: contentType,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		data:        encoded.Bytes(),
+		thumbnails:  make(map[int][]byte),
+	}
+	sum := sha256.Sum256(img.data)
+	img.Hash = hex.EncodeToString(sum[:])
+
+	thumbnailType := thumbnailContentType(contentType)
+	for _, size := range s.config.ThumbnailSizes {
+		// Thumbnails are only made for images bigger than the thumbnail
+		if size <= 0 || (img.Width <= size && img.Height <= size) {
+			continue
+		}
+		var thumbnail bytes.Buffer
+		if err := encode(&thumbnail, resize(still, size), thumbnailType); err != nil {
+			return nil, err
+		}
+		img.thumbnails[size] = thumbnail.Bytes()
+	}
+	return img, nil
+}
+
+// Save stores the image and its thumbnails. Blobs that already exist are kept,
+// since a blob with the same name has the same content.
+func (s *Service) Save(img *Image) error {
+	type file struct {
+		data        []byte
+		contentType string
+	}
+	files := map[string]file{img.FileName(): {img.data, img.ContentType}}
+	for size, data := range img.thumbnails {
+		files[ThumbnailFileName(img.Hash, img.ContentType, size)] = file{data, thumbnailContentType(img.ContentType)}
+	}
+	for name, f := range files {
+		exists, err := s.store.Exists(name)
+		if err == nil && !exists {
+			err = s.store.Put(name, f.data, f.contentType)
+		}
+		if err != nil {
+			return fmt.Errorf("saving %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Delete removes the image and its thumbnails from the store. Blobs that are already gone are ignored.
+func (s *Service) Delete(m model.Media) error {
+	names := []string{m.FileName}
+	for _, size := range m.ThumbnailSizes {
+		names = append(names, ThumbnailFileName(m.Hash, m.ContentType, size))
+	}
+	for _, name := range names {
+		if err := s.store.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetURLs fills in the public URLs of the image and its thumbnails from the stored file names.
+func (s *Service) SetURLs(m *model.Media) {
+	s.setURLs(m, s.store.URL)
+}
+
+// SetSignedURLs fills in URLs that expire after MEDIA_SIGNED_URL_TTL, for images that not everyone can see.
+func (s *Service) SetSignedURLs(m *model.Media) {
+	s.setURLs(m, func(name string) string {
+		return s.store.SignedURL(name, s.config.SignedURLTTL)
+	})
+}
+
+func (s *Service) setURLs(m *model.Media, url func(name string) string) {
+	m.URL = url(m.FileName)
+	m.Thumbnails = make(map[string]string)
+	for _, size := range m.ThumbnailSizes {
+		m.Thumbnails[strconv.Itoa(size)] = url(ThumbnailFileName(m.Hash, m.ContentType, size))
+	}
+}
+
+// ThumbnailFileName is the name of the thumbnail of the given size of an image.
+func ThumbnailFileName(hash, contentType string, size int) string {
+	return hash + "_" + strconv.Itoa(size) + extension(thumbnailContentType(contentType))
+}
+
+// thumbnailContentType is JPEG for photos and PNG for everything else, which may be transparent.
+// Thumbnails of GIFs are still images.
+func thumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+func extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+func encode(w io.Writer, img image.Image, contentType string) error {
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return png.Encode(w, img)
+}
+
+// resize scales the image down so its longest edge is size, keeping the aspect ratio.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := size, bounds.Dy()*size/bounds.Dx()
+	if bounds.Dy() > bounds.Dx() {
+		width, height = bounds.Dx()*size/bounds.Dy(), size
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// isOpaque reports whether the image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
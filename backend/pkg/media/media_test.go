@@ -0,0 +1,165 @@
+package media
+
+import (
+	"backend/pkg/config"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var testConfig = config.MediaConfig{
+	MaxBytes:       1 << 20,
+	MaxDimension:   1000,
+	MaxPixels:      500_000,
+	ThumbnailSizes: []int{100},
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeGIF returns an animation on a width x height canvas with a frame of each of the sizes.
+func encodeGIF(t *testing.T, width, height int, frames ...image.Point) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	animation := &gif.GIF{Config: image.Config{ColorModel: palette, Width: width, Height: height}}
+	for _, size := range frames {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, size.X, size.Y), palette))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// repeat returns n times the frame size.
+func repeat(n int, size image.Point) []image.Point {
+	frames := make([]image.Point, n)
+	for i := range frames {
+		frames[i] = size
+	}
+	return frames
+}
+
+func TestProcessLimits(t *testing.T) {
+	small := image.Pt(300, 300)
+	animation := encodeGIF(t, 300, 300, repeat(3, small)...)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"png", encodePNG(t, 400, 300), nil},
+		{"jpeg", encodeJPEG(t, 400, 300), nil},
+		{"gif", animation, nil},
+		{"too many bytes", append(encodePNG(t, 10, 10), make([]byte, testConfig.MaxBytes)...), ErrTooLarge},
+		{"text", []byte("just some text, not an image"), ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedType},
+		{"bmp", append([]byte("BM"), make([]byte, 64)...), ErrUnsupportedType},
+		{"truncated png", encodePNG(t, 400, 300)[:40], ErrInvalidImage},
+		{"too wide", encodePNG(t, testConfig.MaxDimension+1, 1), ErrTooManyPixels},
+		{"too tall", encodePNG(t, 1, testConfig.MaxDimension+1), ErrTooManyPixels},
+		{"too many pixels", encodePNG(t, 800, 800), ErrTooManyPixels},
+		{"max gif frames", encodeGIF(t, 1, 1, repeat(maxGIFFrames, image.Pt(1, 1))...), nil},
+		{"too many gif frames", encodeGIF(t, 1, 1, repeat(maxGIFFrames+1, image.Pt(1, 1))...), ErrTooManyPixels},
+		// Every frame is within MaxPixels, but all of them together are not
+		{"gif frames within pixel budget", encodeGIF(t, 300, 300, repeat(5, small)...), nil},
+		{"gif frames over pixel budget", encodeGIF(t, 300, 300, repeat(6, small)...), ErrTooManyPixels},
+		// Frames smaller than the canvas only count with their own size
+		{"small gif frames on a large canvas", encodeGIF(t, 700, 700, append([]image.Point{{700, 700}}, repeat(100, image.Pt(10, 10))...)...), nil},
+		{"truncated gif", animation[:len(animation)/2], ErrInvalidImage},
+	}
+	service := NewService(testConfig, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := service.Process(bytes.NewReader(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Process() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if img.Size() == 0 || len(img.Hash) != 64 || !strings.HasPrefix(img.FileName(), img.Hash+".") {
+				t.Errorf("Process() = %s (%d bytes)", img.FileName(), img.Size())
+			}
+		})
+	}
+}
+
+func TestProcessOutput(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           []byte
+		wantType       string
+		wantWidth      int
+		wantHeight     int
+		wantThumbnails []int
+	}{
+		{"png", encodePNG(t, 400, 300), "image/png", 400, 300, []int{100}},
+		{"jpeg", encodeJPEG(t, 400, 300), "image/jpeg", 400, 300, []int{100}},
+		{"gif", encodeGIF(t, 200, 50, image.Pt(200, 50), image.Pt(20, 20)), "image/gif", 200, 50, []int{100}},
+		{"smaller than the thumbnail", encodePNG(t, 80, 100), "image/png", 80, 100, []int{}},
+	}
+	service := NewService(testConfig, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := service.Process(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ContentType != tt.wantType || img.Width != tt.wantWidth || img.Height != tt.wantHeight {
+				t.Errorf("Process() = %s %dx%d, want %s %dx%d", img.ContentType, img.Width, img.Height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+			sizes := img.ThumbnailSizes()
+			if len(sizes) != len(tt.wantThumbnails) || (len(sizes) > 0 && sizes[0] != tt.wantThumbnails[0]) {
+				t.Errorf("thumbnails = %v, want %v", sizes, tt.wantThumbnails)
+			}
+			for size, data := range img.thumbnails {
+				config, _, err := image.DecodeConfig(bytes.NewReader(data))
+				if err != nil || max(config.Width, config.Height) != size {
+					t.Errorf("thumbnail %d is %dx%d (%v)", size, config.Width, config.Height, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	data := encodeGIF(t, 100, 100, image.Pt(100, 100), image.Pt(10, 20), image.Pt(30, 30))
+	frames, area, err := gifFrames(data, 1<<30)
+	if err != nil || frames != 3 || area != 100*100+10*20+30*30 {
+		t.Errorf("gifFrames() = %d frames, %d pixels, %v; want 3 frames, %d pixels", frames, area, err, 100*100+10*20+30*30)
+	}
+	// Counting stops once the budget is exceeded
+	if frames, area, err := gifFrames(data, 5000); err != nil || frames != 1 || area != 10000 {
+		t.Errorf("gifFrames() over budget = %d frames, %d pixels, %v; want to stop after the first frame", frames, area, err)
+	}
+	if _, _, err := gifFrames(data[:len(data)-5], 1<<30); err == nil {
+		t.Error("gifFrames() of a truncated GIF succeeded")
+	}
+}
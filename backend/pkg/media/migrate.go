@@ -0,0 +1,51 @@
+package media
+
+import (
+	"fmt"
+	"io"
+)
+
+// Migrate copies every blob of from to to, skipping blobs that to already has, and returns how many it copied.
+// With deleteSource the blobs are removed from from once they are copied.
+func Migrate(from, to BlobStore, deleteSource bool) (int, error) {
+	names, err := from.List()
+	if err != nil {
+		return 0, fmt.Errorf("listing blobs: %w", err)
+	}
+	copied := 0
+	for _, name := range names {
+		exists, err := to.Exists(name)
+		if err != nil {
+			return copied, fmt.Errorf("checking %s: %w", name, err)
+		}
+		if !exists {
+			if err := copyBlob(from, to, name); err != nil {
+				return copied, err
+			}
+			copied++
+			fmt.Println("Copied", name)
+		}
+		if deleteSource {
+			if err := from.Delete(name); err != nil {
+				return copied, fmt.Errorf("deleting %s: %w", name, err)
+			}
+		}
+	}
+	return copied, nil
+}
+
+func copyBlob(from, to BlobStore, name string) error {
+	blob, err := from.Open(name)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob.Content)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	if err := to.Put(name, data, blob.ContentType); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it has none.
+// Re-encoding drops the EXIF data, so the orientation has to be applied to the pixels first.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// Start of scan: the metadata segments are over
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD of the TIFF structure inside the EXIF segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation returns the image turned and flipped so it displays upright without the EXIF orientation.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// Orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored and rotated 270° clockwise
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored and rotated 90° clockwise
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 270° clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], rgba.Pix[rgba.PixOffset(x, y):rgba.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
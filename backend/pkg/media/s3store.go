@@ -0,0 +1,313 @@
+package media
+
+import (
+	"backend/pkg/config"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3MaxPresignTTL is the longest validity S3 accepts for presigned URLs.
+const s3MaxPresignTTL = 7 * 24 * time.Hour
+
+// S3Store keeps blobs in a bucket of an S3 compatible object storage (AWS S3, MinIO, ...).
+// Requests are signed with AWS Signature Version 4. With path style the bucket is part of the path
+// (http://localhost:9000/bucket/name), which MinIO and most local stand-ins expect.
+type S3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	baseURL   string
+	client    *http.Client
+}
+
+func NewS3Store(cfg config.S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("the s3 media store needs MEDIA_S3_BUCKET, MEDIA_S3_ACCESS_KEY_ID and MEDIA_S3_SECRET_ACCESS_KEY")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid MEDIA_S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	s := &S3Store{
+		endpoint:  endpoint,
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKeyID,
+		secretKey: cfg.SecretAccessKey,
+		pathStyle: cfg.PathStyle,
+		baseURL:   strings.TrimSuffix(cfg.BaseURL, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if s.baseURL == "" {
+		s.baseURL = strings.TrimSuffix(s.objectURL("").String(), "/")
+	}
+	return s, nil
+}
+
+// objectURL is the URL of the object with the name, or of the bucket if name is empty.
+func (s *S3Store) objectURL(name string) *url.URL {
+	u := *s.endpoint
+	u.RawQuery = ""
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + name
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	return &u
+}
+
+func (s *S3Store) Put(name string, data []byte, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	resp, err := s.do(http.MethodPut, name, nil, header, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3Error(resp, http.StatusOK)
+}
+
+// Open downloads the whole object; images are small enough to keep in memory.
+func (s *S3Store) Open(name string) (*Blob, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err := s3Error(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Blob{
+		Content:     bytes.NewReader(data),
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        int64(len(data)),
+		ModTime:     modTime,
+	}, nil
+}
+
+func (s *S3Store) Exists(name string) (bool, error) {
+	resp, err := s.do(http.MethodHead, name, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := s3Error(resp, http.StatusOK); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Store) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return s3Error(resp, http.StatusNoContent, http.StatusOK)
+}
+
+// List pages through the bucket with ListObjectsV2.
+func (s *S3Store) List() ([]string, error) {
+	names := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = s3Error(resp, http.StatusOK)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			names = append(names, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// URL is the object under the public base URL, e.g. a CDN in front of the bucket.
+func (s *S3Store) URL(name string) string {
+	return s.baseURL + "/" + url.PathEscape(name)
+}
+
+// SignedURL is a presigned GET URL, which works even if the bucket isn't public.
+func (s *S3Store) SignedURL(name string, ttl time.Duration) string {
+	if ttl > s3MaxPresignTTL {
+		ttl = s3MaxPresignTTL
+	}
+	now := time.Now().UTC()
+	u := s.objectURL(name)
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	header := http.Header{}
+	header.Set("Host", u.Host)
+	signature := s.signature(now, http.MethodGet, u, query, header, []string{"host"}, "UNSIGNED-PAYLOAD")
+	u.RawQuery = s3Query(query) + "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+// do sends a signed request for the object with the name, or for the bucket if name is empty.
+func (s *S3Store) do(method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	now := time.Now().UTC()
+	u := s.objectURL(name)
+	u.RawQuery = s3Query(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("Host", u.Host)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{}
+	for key := range req.Header {
+		signed = append(signed, strings.ToLower(key))
+	}
+	sort.Strings(signed)
+	signature := s.signature(now, method, u, query, req.Header, signed, payloadHash)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+s.scope(now)+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+	req.Header.Del("Host")
+	return s.client.Do(req)
+}
+
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature computes the Signature Version 4 of a request. signedHeaders must be lower case and sorted.
+func (s *S3Store) signature(now time.Time, method string, u *url.URL, query url.Values, header http.Header, signedHeaders []string, payloadHash string) string {
+	canonicalHeaders := ""
+	for _, key := range signedHeaders {
+		canonicalHeaders += key + ":" + strings.TrimSpace(header.Get(key)) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		s3Escape(u.Path, false),
+		s3Query(query),
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query is the canonical query string: escaped and sorted by key.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything except unreserved characters, and slashes unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error returns an error with the S3 error message unless the response has one of the expected statuses.
+func s3Error(resp *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	var result struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+	return fmt.Errorf("s3 %s %s: %s %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, result.Code, result.Message)
+}
@@ -0,0 +1,72 @@
+package media
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yearInSeconds is how long content-addressed files can be cached, they never change.
+const yearInSeconds = 365 * 24 * 60 * 60
+
+// hashedFileName matches the names Save stores images and thumbnails under.
+var hashedFileName = regexp.MustCompile(`^([0-9a-f]{64})(_[0-9]+)?\.(jpg|png|gif)$`)
+
+// signedURLVerifier is implemented by stores whose signed URLs point at this server, like LocalStore.
+type signedURLVerifier interface {
+	VerifySignedURL(name string, query url.Values) error
+}
+
+// HashFromFileName returns the content hash of an image or thumbnail file name.
+// It returns false for other names, e.g. avatars uploaded before images were content-addressed.
+func HashFromFileName(name string) (string, bool) {
+	match := hashedFileName.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// VerifySignedURL reports whether the request carries a valid signature for the blob, see BlobStore.SignedURL.
+func (s *Service) VerifySignedURL(name string, query url.Values) bool {
+	verifier, ok := s.store.(signedURLVerifier)
+	return ok && query.Has("signature") && verifier.VerifySignedURL(name, query) == nil
+}
+
+// Serve sends the blob with caching headers, conditional requests (ETag, Last-Modified) and range requests.
+// Content-addressed files never change, so they can be cached for a long time: by anyone if public is set,
+// otherwise only by the browser and for MEDIA_SIGNED_URL_TTL, since who can see them may change.
+func (s *Service) Serve(w http.ResponseWriter, r *http.Request, name string, public bool) {
+	blob, err := s.store.Open(name)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading image: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	if blob.ContentType != "" {
+		w.Header().Set("Content-Type", blob.ContentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, hashed := HashFromFileName(name)
+	switch {
+	case hashed && public:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(yearInSeconds)+", immutable")
+	case hashed:
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(s.config.SignedURLTTL.Seconds())))
+	default:
+		// Legacy files are overwritten when the avatar changes
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	if hashed {
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(name, filepath.Ext(name))+`"`)
+	}
+	http.ServeContent(w, r, name, blob.ModTime, blob.Content)
+}
@@ -0,0 +1,57 @@
+package media
+
+import (
+	"backend/pkg/config"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by BlobStore.Open when there is no blob with the name.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores the files of uploaded images. Names are flat file names like "<hash>_160.jpg".
+type BlobStore interface {
+	// Put stores the data under the name, replacing an existing blob
+	Put(name string, data []byte, contentType string) error
+	// Open returns ErrNotFound if the blob doesn't exist. The blob must be closed.
+	Open(name string) (*Blob, error)
+	Exists(name string) (bool, error)
+	// Delete removes the blob; deleting a blob that doesn't exist is not an error
+	Delete(name string) error
+	// List returns the names of all blobs, e.g. to migrate them to another store
+	List() ([]string, error)
+	// URL is the public URL of the blob
+	URL(name string) string
+	// SignedURL is a URL of the blob that only works until ttl has passed
+	SignedURL(name string, ttl time.Duration) string
+}
+
+// Blob is an opened blob. Content can seek, so it can be served with http.ServeContent (range requests).
+type Blob struct {
+	Content     io.ReadSeeker
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+	close       func() error
+}
+
+func (b *Blob) Close() error {
+	if b.close == nil {
+		return nil
+	}
+	return b.close()
+}
+
+// NewStore creates the store of the kind, "local" or "s3", from the media configuration.
+func NewStore(kind string, cfg config.MediaConfig) (BlobStore, error) {
+	switch kind {
+	case "local":
+		return NewLocalStore(cfg.Dir, cfg.BaseURL, cfg.SigningKey), nil
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown media store %q, use local or s3", kind)
+	}
+}
@@ -1,62 +1,148 @@
 package middleware
 
-// ----- DEPRECATED -----
-// import (
-// 	"backend/pkg/repository"
-// 	"context"
-// 	"fmt"
-// 	"net/http"
-// 	"time"
-// )
-
-// // CheckAuthMiddleware is a middleware function that checks if the user is authenticated.
-// // It checks for the presence of a session token cookie in the request.
-// // If the session cookie doesn't exist, it sets isAuthenticated to false and returns an unauthorized error.
-// // If there is an error checking the session token, it returns an internal server error.
-// // If the session token is valid, it stores the user ID in the request context and calls the next handler.
-// func CheckAuthMiddleware(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		cookie, err := r.Cookie("session_token")
-// 		if err != nil {
-//             if err == http.ErrNoCookie {
-//                 // If the session cookie doesn't exist, set isAuthenticated to false
-//                 http.Error(w, "User not authenticated", http.StatusUnauthorized)
-//                 return
-//             } else {
-//                 http.Error(w, "Error checking session token: " + err.Error(), http.StatusInternalServerError)
-//                 return
-//             }
-//         }
-// 		type contextKey string
-
-// 		const userIDKey contextKey = "AuthUserID"
-
-// 		userID, err := ConfirmAuthentication(cookie)
-// 		if err != nil {
-// 			http.Error(w, "Error confirming authentication: "+err.Error(), http.StatusInternalServerError)
-// 			return
-// 		}
-
-// 		// Store the user ID in the request context
-// 		ctx := context.WithValue(r.Context(), userIDKey, userID)
-// 		next.ServeHTTP(w, r.WithContext(ctx))
-// 	})
-// }
-
-// // ConfirmAuthentication checks the validity of a session token stored in a cookie.
-// // It takes a pointer to an http.Cookie as input and returns the user ID associated with the session token and an error, if any.
-// // If the session token is invalid or expired, it returns an error.
-// // The function uses the GetSessionBySessionToken function from the repository package to retrieve the session information from the database.
-// func ConfirmAuthentication(cookie *http.Cookie, sessionRepo *repository.SessionRepository) (int, error) {
-// 	sessionToken := cookie.Value
-
-// 	session, err := sessionRepo.GetSessionBySessionToken(sessionToken)
-// 	if err != nil {
-// 		fmt.Println("Could not get session token: ", err)
-// 		return 0, err
-// 	}
-// 	if time.Now().After(session.ExpiresAt) {
-// 		return 0, fmt.Errorf("session token expired: %v", session.ExpiresAt)
-// 	}
-// 	return session.UserID, nil
-// }
\ No newline at end of file
+import (
+	"backend/pkg/repository"
+	"backend/util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// contextKey is the type of the keys the middleware stores in the request context.
+type contextKey string
+
+const userIDKey contextKey = "AuthUserID"
+
+// ErrNotAuthenticated is returned by GetUserID when the request did not pass through the auth middleware.
+var ErrNotAuthenticated = errors.New("user not authenticated")
+
+// AuthMiddleware validates the session or API token of every request that is routed to a non-public endpoint.
+type AuthMiddleware struct {
+	sessionRepo       *repository.SessionRepository
+	apiTokenRepo      *repository.APITokenRepository
+	publicRoutes      map[string]bool
+	optionalRoutes    map[string]bool
+	routeScopes       map[string]string
+	sessionOnlyRoutes map[string]bool
+}
+
+// NewAuthMiddleware creates a new instance of AuthMiddleware.
+func NewAuthMiddleware(sessionRepo *repository.SessionRepository, apiTokenRepo *repository.APITokenRepository) *AuthMiddleware {
+	return &AuthMiddleware{
+		sessionRepo:       sessionRepo,
+		apiTokenRepo:      apiTokenRepo,
+		publicRoutes:      make(map[string]bool),
+		optionalRoutes:    make(map[string]bool),
+		routeScopes:       make(map[string]string),
+		sessionOnlyRoutes: make(map[string]bool),
+	}
+}
+
+// Public marks the given route path templates (as registered on the mux router) as reachable without a session.
+func (m *AuthMiddleware) Public(paths ...string) {
+	for _, path := range paths {
+		m.publicRoutes[path] = true
+	}
+}
+
+// Optional marks route path templates that anonymous users can reach too. A valid session or API token
+// still stores the user ID in the request context, so the handler can decide what the user may see.
+func (m *AuthMiddleware) Optional(paths ...string) {
+	for _, path := range paths {
+		m.optionalRoutes[path] = true
+	}
+}
+
+// Authenticate is a mux middleware that checks the session_token cookie of the request.
+// If the session doesn't exist or has expired, it responds with a 401 JSON error.
+// If the session is valid, it extends its expiry, stores the user ID in the request context and calls the next handler.
+// Requests with an "Authorization: Bearer" header are authenticated by the API token instead, and the cookie is ignored.
+func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.isPublic(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token := BearerToken(r); token != "" {
+			m.authenticateToken(w, r, token, next)
+			return
+		}
+
+		optional := m.optionalRoutes[routePath(r)]
+		sessionToken := util.GetSessionToken(r)
+		if sessionToken == "" {
+			if optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			WriteUnauthorized(w, "User not authenticated")
+			return
+		}
+
+		session, err := m.sessionRepo.ValidateSession(sessionToken)
+		if err != nil {
+			if optional && (err == sql.ErrNoRows || err == repository.ErrSessionExpired) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err == sql.ErrNoRows || err == repository.ErrSessionExpired {
+				WriteUnauthorized(w, "Session is invalid or has expired")
+				return
+			}
+			http.Error(w, "Error checking session token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Activity keeps the session alive; ExtendSession only writes once per refresh interval
+		session, err = m.sessionRepo.ExtendSession(session)
+		if err != nil {
+			http.Error(w, "Error extending session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Store the user ID in the request context
+		ctx := context.WithValue(r.Context(), userIDKey, session.UserID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isPublic reports whether the route matched by the router was marked as public.
+func (m *AuthMiddleware) isPublic(r *http.Request) bool {
+	return m.publicRoutes[routePath(r)]
+}
+
+// routePath returns the path template of the route matched by the router, or "" if no route matched.
+func routePath(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		fmt.Println("Error getting route path template: ", err)
+		return ""
+	}
+	return path
+}
+
+// GetUserID returns the ID of the authenticated user stored in the request context by Authenticate.
+func GetUserID(r *http.Request) (int, error) {
+	userID, ok := r.Context().Value(userIDKey).(int)
+	if !ok {
+		return 0, ErrNotAuthenticated
+	}
+	return userID, nil
+}
+
+// WriteUnauthorized sends a 401 response with the error message as JSON.
+func WriteUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"backend/pkg/config"
+	"backend/util"
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// CSRFMiddleware implements the double-submit cookie pattern.
+// Every response to a safe request makes sure the browser has a csrf_token cookie, which the frontend can read.
+// State-changing requests (POST, PUT, PATCH, DELETE) must echo that value in the X-CSRF-Token header,
+// unless they are authenticated with an API token.
+// A cross-site page can make the browser send the cookie but cannot read it, so it cannot set the header.
+type CSRFMiddleware struct {
+	cookieConfig config.CookieConfig
+}
+
+// NewCSRFMiddleware creates a new instance of CSRFMiddleware.
+func NewCSRFMiddleware(cookieConfig config.CookieConfig) *CSRFMiddleware {
+	return &CSRFMiddleware{cookieConfig: cookieConfig}
+}
+
+// Protect is a mux middleware that issues the CSRF cookie and checks the CSRF header of state-changing requests.
+func (m *CSRFMiddleware) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(csrfCookieName)
+		hasCookie := err == nil && cookie.Value != ""
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !hasCookie {
+				http.SetCookie(w, m.cookieConfig.NewCookie(csrfCookieName, util.GenerateSessionToken(), false))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// API tokens aren't sent by the browser on its own like cookies, so token requests can't be forged
+		if BearerToken(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get(csrfHeaderName)
+		if !hasCookie || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+			writeForbidden(w, "Missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"backend/pkg/repository"
+	"net/http"
+)
+
+// VerificationPolicy limits what users who haven't confirmed their email address can do.
+// The restricted actions ("post", "comment", "chat", "groups", "events") come from config.EmailVerificationConfig.
+type VerificationPolicy struct {
+	userRepo   *repository.UserRepository
+	restricted map[string]bool
+}
+
+// NewVerificationPolicy creates a new instance of VerificationPolicy.
+func NewVerificationPolicy(userRepo *repository.UserRepository, restricted []string) *VerificationPolicy {
+	policy := &VerificationPolicy{userRepo: userRepo, restricted: make(map[string]bool)}
+	for _, action := range restricted {
+		policy.restricted[action] = true
+	}
+	return policy
+}
+
+// Allows reports whether the user may perform the action.
+func (p *VerificationPolicy) Allows(userID int, action string) (bool, error) {
+	if !p.restricted[action] {
+		return true, nil
+	}
+	return p.userRepo.IsEmailVerified(userID)
+}
+
+// Require wraps a handler of an authenticated route so unverified users get a 403 JSON error if the action is restricted.
+func (p *VerificationPolicy) Require(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := GetUserID(r)
+		if err != nil {
+			WriteUnauthorized(w, "User not authenticated")
+			return
+		}
+		allowed, err := p.Allows(userID, action)
+		if err != nil {
+			http.Error(w, "Error checking email verification: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			writeForbidden(w, "Please verify your email address first")
+			return
+		}
+		next(w, r)
+	}
+}
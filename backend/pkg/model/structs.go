@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"backend/pkg/diff"
+	"time"
+)
 
 // Data structures and domain model
 
@@ -19,6 +22,8 @@ type User struct {
 	Profile   string
 	CreatedAt string
 	UpdatedAt string
+	// VerifiedAt is when the user confirmed their email address, zero if they haven't yet
+	VerifiedAt time.Time
 }
 
 type UserList struct {
@@ -43,7 +48,99 @@ type Profile struct {
 }
 
 type LoginData struct {
-	Username string `json:"username"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	RememberMe bool   `json:"remember_me,omitempty"`
+}
+
+// TOTP is the authenticator app secret of a user. 2FA is on once EnabledAt is set.
+type TOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt time.Time
+	// LastUsedStep is the time step of the last accepted code, older and equal steps are rejected
+	LastUsedStep int64
+}
+
+// MFAChallenge is a login whose password was correct but whose second factor is still missing.
+type MFAChallenge struct {
+	Id         int
+	UserID     int
+	RememberMe bool
+	Attempts   int
+	ExpiresAt  time.Time
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type PasswordConfirmRequest struct {
+	Password string `json:"password"`
+}
+
+type LoginTwoFactorRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// UserIdentity links an account at an external OpenID Connect provider to a user.
+type UserIdentity struct {
+	Id          int       `json:"id"`
+	UserID      int       `json:"-"`
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"-"`
+	Email       string    `json:"email,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OIDCLoginState is a login that was sent to an external provider and hasn't come back to the callback yet.
+// LinkUserID is set when a logged in user links the provider to their account instead of logging in.
+type OIDCLoginState struct {
+	Id           int
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUserID   int
+	ExpiresAt    time.Time
+}
+
+// OIDCRegistration is the first login of an unknown provider account. Prefill holds what the provider told us
+// about the user, which the registration form starts with.
+type OIDCRegistration struct {
+	Id            int
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Prefill       RegistrationData
+	ExpiresAt     time.Time
+}
+
+// OIDCRegisterRequest finishes the registration of an OIDC registration token. The password is optional.
+type OIDCRegisterRequest struct {
+	Token string `json:"token"`
+	RegistrationData
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
 	Password string `json:"password"`
 }
 
@@ -59,26 +156,181 @@ type RegistrationData struct {
 	ProfileSetting string `json:"profile_setting,omitempty"`
 }
 
+// ProfileUpdate holds the fields of a profile edit. Nil fields are left unchanged.
+type ProfileUpdate struct {
+	Username       *string `json:"username,omitempty"`
+	Email          *string `json:"email,omitempty"`
+	FirstName      *string `json:"first_name,omitempty"`
+	LastName       *string `json:"last_name,omitempty"`
+	DOB            *string `json:"dob,omitempty"`
+	AvatarURL      *string `json:"-"` // only set by uploading an avatar
+	About          *string `json:"about,omitempty"`
+	ProfileSetting *string `json:"profile_setting,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type AuthResponse struct {
 	IsAuthenticated bool `json:"is_authenticated"`
+	EmailVerified   bool `json:"email_verified"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
 }
 
 type Session struct {
 	Id           int       `json:"id"`
-	SessionToken string    `json:"session_token"`
+	SessionToken string    `json:"-"`
 	UserID       int       `json:"user_id"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSeenAt   time.Time `json:"last_seen_at"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	// AbsoluteExpiresAt is the latest point ExpiresAt can be extended to
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+	RememberMe        bool      `json:"remember_me"`
+	Current           bool      `json:"current"`
+}
+
+// APIToken is a personal access token a user created for scripts and bots.
+// It is sent as "Authorization: Bearer <token>" and only allows what its scopes list.
+type APIToken struct {
+	Id     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	// Prefix is the start of the token, so the user can tell their tokens apart
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	LastUsedIP string    `json:"last_used_ip"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays defaults to API_TOKEN_DEFAULT_TTL
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// Media is an uploaded image. Its file is named after the hash of its content, see pkg/media.
+type Media struct {
+	Id             int               `json:"id"`
+	OwnerID        int               `json:"owner_id"`
+	Hash           string            `json:"-"`
+	FileName       string            `json:"-"`
+	ContentType    string            `json:"content_type"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	SizeBytes      int64             `json:"size_bytes"`
+	ThumbnailSizes []int             `json:"-"`
+	URL            string            `json:"url"`
+	Thumbnails     map[string]string `json:"thumbnails"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// MediaReference is what uses a media row, e.g. ref type "post" and the post ID. RefType is empty for media nothing uses.
+type MediaReference struct {
+	MediaID int
+	OwnerID int
+	RefType string
+	RefID   int
+}
+
+// LoginAttempt is a row of the login_attempts audit table.
+type LoginAttempt struct {
+	Id        int       `json:"id"`
+	Username  string    `json:"username"`
+	UserID    int       `json:"user_id,omitempty"` // 0 if no user matched the username
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"` // success, unknown_user, wrong_password or rate_limited
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginThrottle is the rate limiting state of one account or IP address.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
 }
 
 type Post struct {
+	Id             int             `json:"id"`
+	UserID         int             `json:"user_id"`
+	GroupID        int             `json:"group_id,omitempty"`
+	Title          string          `json:"title"`
+	Content        string          `json:"content,omitempty"`
+	ImageURL       string          `json:"image_url,omitempty"`
+	PrivacySetting string          `json:"privacy_setting"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Images         []Media         `json:"images"`
+	Audience       []int           `json:"audience,omitempty"` // Users who can see a custom post, only shown to the author
+	Author         *Author         `json:"author,omitempty"`
+	Reactions      ReactionSummary `json:"reactions"`
+	// EditedAt is when the post was last edited, nil if it never was; RevisionCount is how many earlier versions it has
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	RevisionCount int        `json:"revision_count"`
+}
+
+// PostRevision is an earlier version of an edited post, the way it was from CreatedAt until ReplacedAt.
+// Versions count from 1, the post as first published; the current post is version RevisionCount + 1.
+type PostRevision struct {
 	Id             int       `json:"id"`
-	UserID         int       `json:"user_id"`
-	GroupID        int       `json:"group_id,omitempty"`
+	PostID         int       `json:"post_id"`
+	Version        int       `json:"version"`
 	Title          string    `json:"title"`
 	Content        string    `json:"content,omitempty"`
 	ImageURL       string    `json:"image_url,omitempty"`
 	PrivacySetting string    `json:"privacy_setting"`
 	CreatedAt      time.Time `json:"created_at"`
+	ReplacedAt     time.Time `json:"replaced_at"`
+}
+
+// PostDiff is what changed between two versions of a post, word by word.
+type PostDiff struct {
+	From           int         `json:"from"`
+	To             int         `json:"to"`
+	Title          []diff.Edit `json:"title"`
+	Content        []diff.Edit `json:"content"`
+	PrivacySetting []diff.Edit `json:"privacy_setting"`
+}
+
+// Author is the short profile shown with a post, so clients don't need to request every author's profile.
+type Author struct {
+	Id        int    `json:"id"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// PostDetail is a single post with its comment count and first page of comments.
+type PostDetail struct {
+	Post
+	CommentCount int `json:"comment_count"`
+	// Comments are the first top-level comments; CommentsNextCursor gets the rest from /post/{id}/comments
+	Comments           []Comment `json:"comments"`
+	CommentsNextCursor string    `json:"comments_next_cursor,omitempty"`
+}
+
+// Cursor is the position of the last item of a page in a list ordered by creation time and ID, like the feed.
+// The next page starts after it.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// FeedPage is a page of the home feed, newest posts first. NextCursor is empty on the last page.
+type FeedPage struct {
+	Posts      []Post `json:"posts"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type CreatePostRequest struct {
@@ -87,22 +339,64 @@ type CreatePostRequest struct {
 	GroupID        int    `json:"group_id,omitempty"`
 	ImageURL       string `json:"image_url,omitempty"`
 	PrivacySetting string `json:"privacy_setting"`
+	Audience       []int  `json:"audience,omitempty"` // IDs of the friends who can see a custom post
 }
 
+// UpdatePostRequest is the body of an edit, the post's ID is in the URL. The image_url of a post can't be edited.
 type UpdatePostRequest struct {
-	Id             int    `json:"id"`
 	Title          string `json:"title"`
 	Content        string `json:"content,omitempty"`
-	ImageURL       string `json:"image_url,omitempty"`
 	PrivacySetting string `json:"privacy_setting"`
+	Audience       []int  `json:"audience,omitempty"` // IDs of the friends who can see a custom post
 }
 
 type Comment struct {
-	Id        int       `json:"id"`
-	PostID    int       `json:"post_id"`
-	UserID    int       `json:"user_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	Id        int             `json:"id"`
+	PostID    int             `json:"post_id"`
+	UserID    int             `json:"user_id"`
+	ParentID  int             `json:"parent_id,omitempty"` // The comment this one replies to
+	Depth     int             `json:"depth"`               // 0 for top-level comments
+	Content   string          `json:"content"`
+	CreatedAt time.Time       `json:"created_at"`
+	Images    []Media         `json:"images"`
+	Author    *Author         `json:"author,omitempty"`
+	Reactions ReactionSummary `json:"reactions"`
+	// ReplyCount is the number of direct replies
+	ReplyCount int `json:"reply_count"`
+	// Deleted comments that have replies stay in their thread as "[deleted]", without author
+	Deleted bool `json:"deleted,omitempty"`
+	// EditedAt is when the content was last changed, nil if it never was
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+}
+
+// CommentRevision is a previous version of an edited comment: its content from CreatedAt until ReplacedAt.
+type CommentRevision struct {
+	Id         int       `json:"id"`
+	CommentID  int       `json:"comment_id"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// CommentPage is a page of comments on a post, or of replies to a comment, oldest first.
+// NextCursor is empty on the last page.
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Reactions users can give posts and comments.
+var ReactionKinds = []string{"like", "love", "haha", "wow", "sad", "angry"}
+
+// ReactionSummary counts the reactions of a post or comment by kind, with the reaction of the user viewing it.
+type ReactionSummary struct {
+	Counts     map[string]int `json:"counts"`
+	Total      int            `json:"total"`
+	MyReaction string         `json:"my_reaction,omitempty"`
+}
+
+type ReactionRequest struct {
+	Reaction string `json:"reaction"`
 }
 
 type UpdateCommentRequest struct {
@@ -156,6 +450,8 @@ type Notification struct {
 	Message   string    `json:"message"`
 	IsRead    bool      `json:"is_read"`
 	CreatedAt time.Time `json:"created_at"`
+	RefType   string    `json:"ref_type,omitempty"` // What the notification is about, e.g. "post"
+	RefID     int       `json:"ref_id,omitempty"`
 }
 
 type GroupInvitation struct {
@@ -179,6 +475,29 @@ type Event struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// SearchResult is a user, post, group or event matching a search. Title and Snippet are HTML escaped, with the
+// matching words in <mark> tags: the username and name of users, the title and content of posts, the title and
+// description of groups and the title and location of events.
+type SearchResult struct {
+	Type    string  `json:"type"`
+	Id      int     `json:"id"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet,omitempty"`
+	Author  *Author `json:"author,omitempty"` // Of posts
+	// AvatarURL of users, if the searching user can see it
+	AvatarURL string `json:"avatar_url,omitempty"`
+	// Score ranks the results of a type, higher is better
+	Score float64 `json:"score"`
+}
+
+// SearchResults are the best matches of each type, best first.
+type SearchResults struct {
+	Users  []SearchResult `json:"users"`
+	Posts  []SearchResult `json:"posts"`
+	Groups []SearchResult `json:"groups"`
+	Events []SearchResult `json:"events"`
+}
+
 type EventAttendance struct {
 	Id        int       `json:"id"`
 	EventId   int       `json:"event_id"`
@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking the exp and iat claims.
+const clockSkew = time.Minute
+
+// ErrInvalidIDToken is returned when the ID token fails validation.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Claims are the standard claims of the ID token that are used to find or register the user.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Birthdate         string   `json:"birthdate"`
+}
+
+// audience accepts the aud claim both as a single string and as an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// jsonWebKey is one key of the provider's JWKS document. Only RSA and P-256 keys are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verifyIDToken checks the signature of the ID token against the provider's keys and validates
+// the issuer, audience, expiry and nonce as required by OpenID Connect Core 1.0, section 3.1.3.7.
+func (p *Provider) verifyIDToken(rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed header", ErrInvalidIDToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+
+	key, err := p.signingKey(header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(header.Alg, key, digest[:], signature) {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed claims", ErrInvalidIDToken)
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !contains(claims.Audience, p.clientID):
+		return Claims{}, fmt.Errorf("%w: token is not for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID:
+		return Claims{}, fmt.Errorf("%w: unexpected authorized party", ErrInvalidIDToken)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: token issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider's public key with the given key ID.
+// An unknown key ID refreshes the key set once, since providers rotate their keys.
+func (p *Provider) signingKey(kid string) (interface{}, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching keys of %s failed: %w", p.Name, err)
+	}
+	p.keys = make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+// lookupKey finds a cached key. A token without a key ID is accepted only if the provider has a single key.
+func (p *Provider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// publicKey converts the JWK to an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("unsupported RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks an RS256 or ES256 signature. Any other algorithm, including "none", is rejected.
+func verifySignature(alg string, key interface{}, digest, signature []byte) bool {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature) == nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, digest, r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of the token.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
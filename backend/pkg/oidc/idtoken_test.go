@@ -0,0 +1,109 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockProvider(t)
+	now := time.Now()
+	tests := []struct {
+		name string
+		// edit changes the valid claims of the token
+		edit    func(claims map[string]interface{})
+		wantErr bool
+	}{
+		{"valid", func(claims map[string]interface{}) {}, false},
+		{"audience list with authorized party", func(claims map[string]interface{}) {
+			claims["aud"] = []string{"other", "client"}
+			claims["azp"] = "client"
+		}, false},
+		{"single audience list", func(claims map[string]interface{}) { claims["aud"] = []string{"client"} }, false},
+		{"audience list without authorized party", func(claims map[string]interface{}) {
+			claims["aud"] = []string{"other", "client"}
+		}, true},
+		{"audience list with another authorized party", func(claims map[string]interface{}) {
+			claims["aud"] = []string{"other", "client"}
+			claims["azp"] = "other"
+		}, true},
+		{"other audience", func(claims map[string]interface{}) { claims["aud"] = "other" }, true},
+		{"missing audience", func(claims map[string]interface{}) { delete(claims, "aud") }, true},
+		{"nonce mismatch", func(claims map[string]interface{}) { claims["nonce"] = "nonce-2" }, true},
+		{"missing nonce", func(claims map[string]interface{}) { delete(claims, "nonce") }, true},
+		{"other issuer", func(claims map[string]interface{}) { claims["iss"] = "https://evil.example" }, true},
+		{"issuer with trailing slash", func(claims map[string]interface{}) { claims["iss"] = m.server.URL + "/" }, false},
+		{"expired", func(claims map[string]interface{}) { claims["exp"] = now.Add(-2 * clockSkew).Unix() }, true},
+		{"expired within clock skew", func(claims map[string]interface{}) { claims["exp"] = now.Add(-clockSkew / 2).Unix() }, false},
+		{"missing expiry", func(claims map[string]interface{}) { delete(claims, "exp") }, true},
+		{"issued in the future", func(claims map[string]interface{}) { claims["iat"] = now.Add(2 * clockSkew).Unix() }, true},
+		{"missing subject", func(claims map[string]interface{}) { delete(claims, "sub") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.validClaims("nonce-1")
+			tt.edit(claims)
+			_, err := m.provider().verifyIDToken(m.sign(t, "RS256", "rsa", claims), "nonce-1")
+			if tt.wantErr && !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("verifyIDToken() error = %v, want ErrInvalidIDToken", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("verifyIDToken() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenSignature(t *testing.T) {
+	m := newMockProvider(t)
+	claims := m.validClaims("nonce-1")
+	valid := m.sign(t, "RS256", "rsa", claims)
+	parts := strings.Split(valid, ".")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forger := &mockProvider{server: m.server, rsaKey: otherKey}
+
+	tamperedClaims := m.validClaims("nonce-1")
+	tamperedClaims["sub"] = "subject-2"
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"RS256", valid, false},
+		{"ES256", m.sign(t, "ES256", "ec", claims), false},
+		{"signed by another key", forger.sign(t, "RS256", "rsa", claims), true},
+		{"tampered claims", parts[0] + "." + encodeSegment(t, tamperedClaims) + "." + parts[2], true},
+		{"alg none", encodeSegment(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + parts[1] + ".", true},
+		{"RS256 token with the EC key", m.sign(t, "RS256", "ec", claims), true},
+		{"unknown key", m.sign(t, "RS256", "missing", claims), true},
+		{"encryption key", m.sign(t, "RS256", "enc", claims), true},
+		{"no key ID with several keys", m.sign(t, "RS256", "", claims), true},
+		{"malformed", "not-a-token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.provider().verifyIDToken(tt.token, "nonce-1")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("verifyIDToken() accepted the token with claims %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyIDToken() error = %v", err)
+			}
+			if got.Subject != "subject-1" || got.Nonce != "nonce-1" || len(got.Audience) != 1 || got.Audience[0] != "client" {
+				t.Errorf("verifyIDToken() claims = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := CodeChallenge(verifier); got != want {
+		t.Errorf("CodeChallenge(%q) = %q, want %q", verifier, got, want)
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		value, err := RandomString()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(decoded) != 32 {
+			t.Fatalf("RandomString() = %q, want 32 base64url encoded bytes", value)
+		}
+		if seen[value] {
+			t.Fatalf("RandomString() returned %q twice", value)
+		}
+		seen[value] = true
+	}
+}
@@ -0,0 +1,188 @@
+// Package oidc is a small OpenID Connect client for logging in with external identity providers.
+// It implements the authorization code flow with PKCE, provider discovery and ID token validation,
+// and works with any provider that publishes /.well-known/openid-configuration.
+package oidc
+
+import (
+	"backend/pkg/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryTTL is how long the discovery document and the signing keys of a provider are cached.
+const discoveryTTL = time.Hour
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// discovery holds the fields of the provider's /.well-known/openid-configuration that the client uses.
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider is one configured identity provider. Discovery happens on first use, so a provider that is down
+// doesn't keep the server from starting.
+type Provider struct {
+	Name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	mu           sync.Mutex
+	discovery    *discovery
+	discoveredAt time.Time
+	keys         map[string]interface{}
+}
+
+// NewProvider creates a provider from its configuration. redirectURL is the callback URL registered with the provider.
+func NewProvider(cfg config.OIDCProviderConfig, redirectURL string) *Provider {
+	return &Provider{
+		Name:         cfg.Name,
+		issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		redirectURL:  redirectURL,
+		scopes:       cfg.Scopes,
+	}
+}
+
+// discover returns the discovery document, fetching it if it isn't cached.
+func (p *Provider) discover() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, nil
+	}
+
+	var doc discovery
+	if err := getJSON(p.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("discovery of %s failed: %w", p.Name, err)
+	}
+	// The issuer in the document has to be the one we asked (OpenID Connect Discovery 1.0, section 4.3)
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery of %s returned issuer %q", p.Name, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery of %s is missing endpoints", p.Name)
+	}
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	p.keys = nil
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page the user is redirected to.
+// state and nonce are checked by the callback; codeChallenge is the S256 PKCE challenge of the code verifier.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(p.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// tokenResponse is the answer of the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the authorization code for tokens and returns the validated claims of the ID token.
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (Claims, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.clientID)
+	useBasicAuth := p.clientSecret != "" && (len(doc.TokenAuthMethods) == 0 || contains(doc.TokenAuthMethods, "client_secret_basic"))
+	if p.clientSecret != "" && !useBasicAuth {
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Claims{}, err
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return Claims{}, fmt.Errorf("invalid token response (status %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return Claims{}, fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return Claims{}, errors.New("token response has no id_token")
+	}
+	return p.verifyIDToken(token.IDToken, nonce)
+}
+
+// getJSON fetches url and decodes the JSON response into v.
+func getJSON(url string, v interface{}) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
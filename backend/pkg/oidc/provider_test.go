@@ -0,0 +1,216 @@
+package oidc
+
+import (
+	"backend/pkg/config"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mockProvider is an OpenID Connect provider for tests. It serves discovery, an RSA ("rsa") and a P-256 ("ec")
+// signing key, and a token endpoint that accepts the code "good-code" with the code verifier of challenge.
+type mockProvider struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// challenge is the PKCE challenge of the login, the token endpoint checks the code verifier against it
+	challenge string
+	// claims are the claims of the ID token the token endpoint returns
+	claims map[string]interface{}
+	// tokenRequests are the forms the token endpoint received
+	tokenRequests []url.Values
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.FillBytes(make([]byte, 32))), "y": encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": encode(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.tokenRequests = append(m.tokenRequests, r.PostForm)
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != "good-code" ||
+			CodeChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": m.sign(t, "RS256", "rsa", m.claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// provider returns a client of the mock provider with the client ID "client".
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(config.OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"openid", "email"},
+	}, "http://localhost:8080/api/auth/oidc/mock/callback")
+}
+
+// validClaims returns the claims of a valid ID token for the client.
+func (m *mockProvider) validClaims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   m.server.URL,
+		"sub":   "subject-1",
+		"aud":   "client",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": nonce,
+		"email": "user@example.com",
+	}
+}
+
+// sign returns an ID token with the claims, signed with the key of the algorithm (RS256 or ES256).
+func (m *mockProvider) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, m.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, m.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	authURL, err := m.provider().AuthCodeURL("state-1", "nonce-1", CodeChallenge("verifier-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Scheme + "://" + parsed.Host + parsed.Path; got != m.server.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %q, want %q", got, m.server.URL+"/authorize")
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "http://localhost:8080/api/auth/oidc/mock/callback",
+		"scope":                 "openid email",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        CodeChallenge("verifier-1"),
+		"code_challenge_method": "S256",
+	}
+	query := parsed.Query()
+	for name, value := range want {
+		if query.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, query.Get(name), value)
+		}
+	}
+	if query.Get("code_verifier") != "" {
+		t.Error("the code verifier must not be sent to the authorization endpoint")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	provider := NewProvider(config.OIDCProviderConfig{Name: "mock", Issuer: m.server.URL + "/other", ClientID: "client"}, "")
+	if _, err := provider.AuthCodeURL("state", "nonce", "challenge"); err == nil {
+		t.Error("AuthCodeURL succeeded with a discovery document of another issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		verifier string
+		nonce    string
+		wantErr  bool
+	}{
+		{"valid", "good-code", "verifier-1", "nonce-1", false},
+		{"wrong code verifier", "good-code", "verifier-2", "nonce-1", true},
+		{"wrong code", "bad-code", "verifier-1", "nonce-1", true},
+		{"nonce of another login", "good-code", "verifier-1", "nonce-2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.challenge = CodeChallenge("verifier-1")
+			m.claims = m.validClaims("nonce-1")
+
+			claims, err := m.provider().Exchange(tt.code, tt.verifier, tt.nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Exchange succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "subject-1" || claims.Email != "user@example.com" {
+				t.Errorf("claims = %+v", claims)
+			}
+			if len(m.tokenRequests) != 1 || m.tokenRequests[0].Get("client_secret") != "" {
+				t.Errorf("token requests = %v, want one with the secret in the Authorization header", m.tokenRequests)
+			}
+		})
+	}
+}
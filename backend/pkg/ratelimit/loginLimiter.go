@@ -0,0 +1,139 @@
+// Package ratelimit protects the login endpoint against password guessing.
+package ratelimit
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/model"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps the LoginThrottle state of every account and IP address.
+// MemoryStore is the default; repository.LoginThrottleRepository keeps the state in SQLite so it survives restarts.
+type Store interface {
+	// Get returns the state of key, or a zero LoginThrottle if the key has no failures.
+	Get(key string) (model.LoginThrottle, error)
+	Save(throttle model.LoginThrottle) error
+	Delete(key string) error
+	// DeleteStale deletes the keys whose last failure is older than window and which are no longer blocked.
+	DeleteStale(window time.Duration) (int64, error)
+}
+
+// LoginLimiter counts failed logins per account and per IP address and blocks a key with exponential backoff,
+// and for LockoutDuration once it reaches its lockout threshold (see config.LoginLimitConfig).
+type LoginLimiter struct {
+	store  Store
+	config config.LoginLimitConfig
+	// mu serializes the read-modify-write of RegisterFailure so concurrent failures are all counted
+	mu sync.Mutex
+}
+
+// NewLoginLimiter creates a new instance of LoginLimiter.
+func NewLoginLimiter(store Store, cfg config.LoginLimitConfig) *LoginLimiter {
+	return &LoginLimiter{store: store, config: cfg}
+}
+
+// AccountKey returns the limiter key of a login name. Names are case-insensitive so "Bob" and "bob" share a counter.
+func AccountKey(name string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// UserKey returns the limiter key of an existing user, shared by all the names (email, username) they log in with.
+func UserKey(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// IPKey returns the limiter key of a client IP address.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the client has to wait before it may try to log in again.
+// It returns zero if none of the keys is blocked.
+func (l *LoginLimiter) Check(keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		throttle, err := l.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining := throttle.BlockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// RegisterFailure counts a failed login for the account and the IP address.
+func (l *LoginLimiter) RegisterFailure(accountKey, ipKey string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.registerFailure(accountKey, l.config.AccountLockoutThreshold); err != nil {
+		return err
+	}
+	return l.registerFailure(ipKey, l.config.IPLockoutThreshold)
+}
+
+func (l *LoginLimiter) registerFailure(key string, lockoutThreshold int) error {
+	now := time.Now()
+	throttle, err := l.store.Get(key)
+	if err != nil {
+		return err
+	}
+	// Failures outside the window don't count anymore
+	if now.Sub(throttle.LastFailureAt) > l.config.Window {
+		throttle.Failures = 0
+	}
+	throttle.Key = key
+	throttle.Failures++
+	throttle.LastFailureAt = now
+
+	switch {
+	case lockoutThreshold > 0 && throttle.Failures >= lockoutThreshold:
+		throttle.BlockedUntil = now.Add(l.config.LockoutDuration)
+	case throttle.Failures > l.config.FreeAttempts:
+		throttle.BlockedUntil = now.Add(l.backoff(throttle.Failures - l.config.FreeAttempts))
+	}
+	return l.store.Save(throttle)
+}
+
+// backoff returns BaseDelay doubled for every failure after the first one past FreeAttempts, capped at MaxDelay.
+func (l *LoginLimiter) backoff(failures int) time.Duration {
+	delay := l.config.BaseDelay
+	for i := 1; i < failures && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	return delay
+}
+
+// RegisterSuccess clears the failures of the account after a successful login.
+// The failures of the IP address are kept, otherwise logging into one's own account would reset
+// the limit of an IP that is guessing the passwords of other accounts.
+func (l *LoginLimiter) RegisterSuccess(accountKey string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.store.Delete(accountKey)
+}
+
+// RunSweeper deletes stale keys from the store right away and then every interval. It never returns.
+func (l *LoginLimiter) RunSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := l.store.DeleteStale(l.config.Window)
+		if err != nil {
+			log.Println("Error purging stale login throttles: ", err)
+		} else if deleted > 0 {
+			log.Println("Purged stale login throttles: ", deleted)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testConfig = config.LoginLimitConfig{
+	FreeAttempts:            3,
+	BaseDelay:               time.Second,
+	MaxDelay:                8 * time.Second,
+	AccountLockoutThreshold: 10,
+	IPLockoutThreshold:      20,
+	LockoutDuration:         15 * time.Minute,
+	Window:                  15 * time.Minute,
+}
+
+func TestBackoff(t *testing.T) {
+	l := NewLoginLimiter(NewMemoryStore(), testConfig)
+	tests := []struct {
+		failures int // failures after the free attempts
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 8 * time.Second},
+		{100, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := l.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// TestLockout registers failures one by one: the free attempts don't block, then the delay doubles up to
+// MaxDelay, and the account is locked out once it reaches its threshold.
+func TestLockout(t *testing.T) {
+	l := NewLoginLimiter(NewMemoryStore(), testConfig)
+	account, ip := AccountKey("bob"), IPKey("192.0.2.1")
+	wantWaits := []time.Duration{
+		0, 0, 0,
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second, 8 * time.Second,
+		15 * time.Minute,
+	}
+	for i, want := range wantWaits {
+		if err := l.RegisterFailure(account, ip); err != nil {
+			t.Fatal(err)
+		}
+		wait, err := l.Check(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !about(wait, want) {
+			t.Errorf("wait after %d failures = %s, want %s", i+1, wait, want)
+		}
+	}
+
+	// The IP address has the same failures but a higher threshold, so it's only delayed
+	if wait, _ := l.Check(ip); !about(wait, 8*time.Second) {
+		t.Errorf("wait of the IP address = %s, want 8s", wait)
+	}
+	// Check returns the longest wait of the keys
+	if wait, _ := l.Check(IPKey("192.0.2.2"), ip, account); !about(wait, 15*time.Minute) {
+		t.Errorf("wait of the account and IP address = %s, want 15m", wait)
+	}
+}
+
+func TestFailuresOutsideWindow(t *testing.T) {
+	store := NewMemoryStore()
+	l := NewLoginLimiter(store, testConfig)
+	account := AccountKey("bob")
+	store.Save(model.LoginThrottle{Key: account, Failures: 9, LastFailureAt: time.Now().Add(-testConfig.Window - time.Minute)})
+
+	if err := l.RegisterFailure(account, IPKey("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	throttle, _ := store.Get(account)
+	if throttle.Failures != 1 {
+		t.Errorf("failures = %d, want the old failures forgotten", throttle.Failures)
+	}
+	if wait, _ := l.Check(account); wait != 0 {
+		t.Errorf("wait = %s, want 0", wait)
+	}
+}
+
+func TestRegisterSuccess(t *testing.T) {
+	l := NewLoginLimiter(NewMemoryStore(), testConfig)
+	account, ip := AccountKey("bob"), IPKey("192.0.2.1")
+	for i := 0; i < testConfig.AccountLockoutThreshold; i++ {
+		l.RegisterFailure(account, ip)
+	}
+	if err := l.RegisterSuccess(account); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := l.Check(account); wait != 0 {
+		t.Errorf("wait of the account = %s, want 0", wait)
+	}
+	// The IP address keeps its failures
+	if wait, _ := l.Check(ip); wait == 0 {
+		t.Error("the failures of the IP address were cleared")
+	}
+}
+
+func TestConcurrentFailures(t *testing.T) {
+	store := NewMemoryStore()
+	l := NewLoginLimiter(store, testConfig)
+	ip := IPKey("192.0.2.1")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.RegisterFailure(AccountKey("bob"), ip)
+		}()
+	}
+	wg.Wait()
+	if throttle, _ := store.Get(ip); throttle.Failures != 50 {
+		t.Errorf("failures = %d, want 50", throttle.Failures)
+	}
+}
+
+func TestAccountKey(t *testing.T) {
+	if AccountKey(" Bob ") != AccountKey("bob") {
+		t.Errorf("AccountKey(%q) = %q, want %q", " Bob ", AccountKey(" Bob "), AccountKey("bob"))
+	}
+}
+
+func TestDeleteStale(t *testing.T) {
+	store := NewMemoryStore()
+	old := time.Now().Add(-time.Hour)
+	store.Save(model.LoginThrottle{Key: "stale", Failures: 3, LastFailureAt: old})
+	store.Save(model.LoginThrottle{Key: "recent", Failures: 3, LastFailureAt: time.Now()})
+	store.Save(model.LoginThrottle{Key: "locked", Failures: 10, LastFailureAt: old, BlockedUntil: time.Now().Add(time.Hour)})
+
+	deleted, err := store.DeleteStale(15 * time.Minute)
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteStale() = %d, %v, want 1", deleted, err)
+	}
+	for key, want := range map[string]int{"stale": 0, "recent": 3, "locked": 10} {
+		if throttle, _ := store.Get(key); throttle.Failures != want {
+			t.Errorf("failures of %s = %d, want %d", key, throttle.Failures, want)
+		}
+	}
+}
+
+// about reports whether wait is want, less the time the test took since the failure was registered.
+func about(wait, want time.Duration) bool {
+	return wait <= want && wait > want-time.Second
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"backend/pkg/model"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the limiter state in a map. The state is lost when the server restarts.
+type MemoryStore struct {
+	mu        sync.Mutex
+	throttles map[string]model.LoginThrottle
+}
+
+// NewMemoryStore creates a new, empty instance of MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{throttles: make(map[string]model.LoginThrottle)}
+}
+
+func (s *MemoryStore) Get(key string) (model.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttles[key], nil
+}
+
+func (s *MemoryStore) Save(throttle model.LoginThrottle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttles[throttle.Key] = throttle
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.throttles, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteStale(window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var deleted int64
+	for key, throttle := range s.throttles {
+		if now.Sub(throttle.LastFailureAt) > window && now.After(throttle.BlockedUntil) {
+			delete(s.throttles, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrAPITokenExpired is returned by ValidateToken when the token exists but its expires_at has passed.
+var ErrAPITokenExpired = errors.New("API token expired")
+
+// apiTokenTouchInterval throttles how often using a token updates last_used_at.
+const apiTokenTouchInterval = time.Minute
+
+// APITokenRepository stores personal API tokens. Only the SHA-256 hash of a token is stored (see util.HashToken).
+type APITokenRepository struct {
+	db *sql.DB
+}
+
+func NewAPITokenRepository(db *sql.DB) *APITokenRepository {
+	return &APITokenRepository{db: db}
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, created_at`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (model.APIToken, error) {
+	var token model.APIToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+	var lastUsedIP sql.NullString
+	err := row.Scan(&token.Id, &token.UserID, &token.Name, &token.Prefix, &scopes, &token.ExpiresAt, &lastUsedAt, &lastUsedIP, &token.CreatedAt)
+	if err != nil {
+		return model.APIToken{}, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	token.LastUsedAt = lastUsedAt.Time
+	token.LastUsedIP = lastUsedIP.String
+	return token, nil
+}
+
+// CreateToken stores a new token and returns it with its ID.
+func (r *APITokenRepository) CreateToken(tokenHash string, token model.APIToken) (model.APIToken, error) {
+	token.CreatedAt = time.Now()
+	result, err := r.db.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Name, tokenHash, token.Prefix, strings.Join(token.Scopes, ","), token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		fmt.Println("Error inserting API token into database")
+		return model.APIToken{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return model.APIToken{}, err
+	}
+	token.Id = int(id)
+	return token, nil
+}
+
+// CountTokens returns how many tokens the user has, including expired ones.
+func (r *APITokenRepository) CountTokens(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+// GetTokensByUserID returns the tokens of the user, newest first. Expired tokens are included so the user can see and delete them.
+func (r *APITokenRepository) GetTokensByUserID(userID int) ([]model.APIToken, error) {
+	rows, err := r.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []model.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// ValidateToken returns the token with the hash if it exists and has not expired.
+// It returns sql.ErrNoRows for an unknown token and ErrAPITokenExpired for an expired one.
+func (r *APITokenRepository) ValidateToken(tokenHash string) (model.APIToken, error) {
+	token, err := scanAPIToken(r.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, tokenHash))
+	if err != nil {
+		return model.APIToken{}, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return model.APIToken{}, ErrAPITokenExpired
+	}
+	return token, nil
+}
+
+// TouchToken records that the token was used from the IP address. Like sessions, it only writes once per interval.
+func (r *APITokenRepository) TouchToken(token model.APIToken, ipAddress string) error {
+	now := time.Now()
+	if now.Sub(token.LastUsedAt) < apiTokenTouchInterval && token.LastUsedIP == ipAddress {
+		return nil
+	}
+	_, err := r.db.Exec(`UPDATE api_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?`, now, ipAddress, token.Id)
+	return err
+}
+
+// DeleteUserToken revokes the token with the ID if it belongs to the user, otherwise it returns sql.ErrNoRows.
+func (r *APITokenRepository) DeleteUserToken(id, userID int) error {
+	result, err := r.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserTokens revokes every token of the user and returns the IDs of the revoked tokens.
+func (r *APITokenRepository) DeleteUserTokens(userID int) ([]int, error) {
+	rows, err := r.db.Query(`SELECT id FROM api_tokens WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.db.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, userID); err != nil {
+		fmt.Println("Error deleting API tokens")
+		return nil, err
+	}
+	return ids, nil
+}
@@ -4,6 +4,7 @@ import (
 	"backend/pkg/model"
 	"database/sql"
 	"fmt"
+	"time"
 )
 
 type CommentRepository struct {
@@ -14,34 +15,116 @@ func NewCommentRepository(db *sql.DB) *CommentRepository {
     return &CommentRepository{db: db}
 }
 
-func (r *CommentRepository) GetCommentsByID(id int) ([]model.Comment, error) {
-    query := `SELECT * FROM comments WHERE post_id = ? OR user_id = ?`
-    rows, err := r.db.Query(query, id, id)
+// commentColumns are the columns scanComment reads, in order.
+const commentColumns = `comments.id, comments.post_id, comments.user_id, comments.parent_id, comments.depth, comments.content, comments.created_at, comments.deleted_at IS NOT NULL, comments.edited_at`
+
+// deletedCommentContent replaces the content of deleted comments that are kept for their replies.
+const deletedCommentContent = "[deleted]"
+
+func scanComment(row interface{ Scan(...interface{}) error }) (model.Comment, error) {
+    var comment model.Comment
+    var parentID sql.NullInt64
+    var editedAt sql.NullTime
+    if err := row.Scan(&comment.Id, &comment.PostID, &comment.UserID, &parentID, &comment.Depth, &comment.Content, &comment.CreatedAt, &comment.Deleted, &editedAt); err != nil {
+        return model.Comment{}, err
+    }
+    comment.ParentID = int(parentID.Int64)
+    if editedAt.Valid {
+        comment.EditedAt = &editedAt.Time
+    }
+    if comment.Deleted {
+        comment.Content = deletedCommentContent
+    }
+    return comment, nil
+}
+
+// scanCommentWithAuthor reads commentColumns followed by the author's username and avatar_url and the reply count.
+// Deleted comments don't reveal who wrote them.
+func scanCommentWithAuthor(row interface{ Scan(...interface{}) error }) (model.Comment, error) {
+    var author model.Author
+    var avatarURL sql.NullString
+    var replyCount int
+    comment, err := scanComment(suffixedScanner{row, []interface{}{&author.Username, &avatarURL, &replyCount}})
+    if err != nil {
+        return model.Comment{}, err
+    }
+    comment.ReplyCount = replyCount
+    if comment.Deleted {
+        comment.UserID = 0
+        return comment, nil
+    }
+    author.Id = comment.UserID
+    author.AvatarURL = avatarURL.String
+    comment.Author = &author
+    return comment, nil
+}
+
+// GetCommentPage returns a page of the top-level comments of the post (parentID 0) or of the replies to a comment,
+// oldest first, with their authors and reply counts. after is the last comment of the previous page, or nil.
+func (r *CommentRepository) GetCommentPage(postID int, parentID int, after *model.Cursor, limit int) ([]model.Comment, error) {
+    var parent interface{}
+    if parentID != 0 {
+        parent = parentID
+    }
+    query := `SELECT ` + commentColumns + `, users.username, users.avatar_url,
+        (SELECT COUNT(*) FROM comments AS replies WHERE replies.parent_id = comments.id)
+    FROM comments
+    JOIN users ON users.id = comments.user_id
+    WHERE comments.post_id = ? AND comments.parent_id IS ?`
+    args := []interface{}{postID, parent}
+    if after != nil {
+        createdAt := after.CreatedAt.UTC().Format("2006-01-02 15:04:05")
+        query += ` AND (datetime(comments.created_at) > ? OR (datetime(comments.created_at) = ? AND comments.id > ?))`
+        args = append(args, createdAt, createdAt, after.ID)
+    }
+    query += ` ORDER BY datetime(comments.created_at), comments.id LIMIT ?`
+    args = append(args, limit)
+
+    rows, err := r.db.Query(query, args...)
     if err != nil {
         return nil, err
     }
     defer rows.Close()
 
-    var comments []model.Comment
+    comments := []model.Comment{}
     for rows.Next() {
-        var comment model.Comment
-        if err := rows.Scan(&comment.Content, &comment.CreatedAt, &comment.PostID, &comment.UserID); err != nil {
+        comment, err := scanCommentWithAuthor(rows)
+        if err != nil {
             return nil, err
         }
         comments = append(comments, comment)
     }
+    return comments, rows.Err()
+}
 
-    if err = rows.Err(); err != nil {
-        return nil, err
-    }
+// CountPostComments returns how many comments the post has, not counting deleted ones.
+func (r *CommentRepository) CountPostComments(postID int) (int, error) {
+    var count int
+    err := r.db.QueryRow(`SELECT COUNT(*) FROM comments WHERE post_id = ? AND deleted_at IS NULL`, postID).Scan(&count)
+    return count, err
+}
 
-    return comments, nil
+// CountRepliers returns how many users replied to the comment, leaving out the user (its author).
+func (r *CommentRepository) CountRepliers(parentID int, exceptUserID int) (int, error) {
+    var count int
+    err := r.db.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM comments WHERE parent_id = ? AND user_id != ? AND deleted_at IS NULL`,
+        parentID, exceptUserID).Scan(&count)
+    return count, err
+}
+
+// GetCommentByID returns sql.ErrNoRows if the comment doesn't exist.
+func (r *CommentRepository) GetCommentByID(id int) (model.Comment, error) {
+    return scanComment(r.db.QueryRow(`SELECT ` + commentColumns + ` FROM comments WHERE id = ?`, id))
 }
 
 func (r *CommentRepository) CreateComment(comment model.Comment) (int64, error) {
-	query := `INSERT INTO comments (post_id, user_id, content) 
-	VALUES (?, ?, ?)`
-	result, err := r.db.Exec(query, comment.PostID, comment.UserID, comment.Content)
+	query := `INSERT INTO comments (post_id, user_id, parent_id, depth, content) 
+	VALUES (?, ?, ?, ?, ?)`
+	var parentID interface{}
+	if comment.ParentID != 0 {
+		parentID = comment.ParentID
+	}
+	result, err := r.db.Exec(query, comment.PostID, comment.UserID, parentID, comment.Depth, comment.Content)
 	if err != nil {
 		return 0, err
 	}
@@ -52,26 +135,123 @@ func (r *CommentRepository) CreateComment(comment model.Comment) (int64, error)
 	return lastInsertID, nil
 }
 
-func (r *CommentRepository) DeleteComment(id int, userid int) error {
-    query := `DELETE FROM comments WHERE id = ? AND user_id = ?`
-    _, err := r.db.Exec(query, id)
+// DeleteComment deletes the comment; callers check who may delete it. A comment with replies is kept as a
+// "[deleted]" placeholder instead, and placeholders without replies left are deleted too.
+func (r *CommentRepository) DeleteComment(id int) error {
+    tx, err := r.db.Begin()
     if err != nil {
         return err
     }
-    return nil
+    defer tx.Rollback()
+
+    var parentID sql.NullInt64
+    err = tx.QueryRow(`SELECT parent_id FROM comments WHERE id = ? AND deleted_at IS NULL`, id).Scan(&parentID)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("no comment found with the specified id")
+    }
+    if err != nil {
+        return err
+    }
+
+    // Earlier versions go with the content
+    if _, err := tx.Exec(`DELETE FROM comment_revisions WHERE comment_id = ?`, id); err != nil {
+        return err
+    }
+
+    var hasReplies bool
+    if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM comments WHERE parent_id = ?)`, id).Scan(&hasReplies); err != nil {
+        return err
+    }
+    if hasReplies {
+        if _, err := tx.Exec(`UPDATE comments SET content = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+            return err
+        }
+        return tx.Commit()
+    }
+    if _, err := tx.Exec(`DELETE FROM comments WHERE id = ?`, id); err != nil {
+        return err
+    }
+
+    // Placeholders only exist for their replies
+    for parentID.Valid {
+        var grandparentID sql.NullInt64
+        err := tx.QueryRow(`SELECT parent_id FROM comments WHERE id = ? AND deleted_at IS NOT NULL
+            AND NOT EXISTS(SELECT 1 FROM comments AS replies WHERE replies.parent_id = comments.id)`, parentID.Int64).Scan(&grandparentID)
+        if err == sql.ErrNoRows {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(`DELETE FROM comments WHERE id = ?`, parentID.Int64); err != nil {
+            return err
+        }
+        parentID = grandparentID
+    }
+    return tx.Commit()
 }
 
+// UpdateComment changes the content of the user's comment and keeps the previous content as a revision.
 func (r *CommentRepository) UpdateComment(commentId int, userId int, comment model.UpdateCommentRequest) error {
-    query := `UPDATE comments SET content = ? WHERE id = ? AND user_id = ?`
-    _, err := r.db.Exec(query, comment.Content, comment.Id, comment.UserID)
+    tx, err := r.db.Begin()
     if err != nil {
         return err
     }
-    return nil
+    defer tx.Rollback()
+
+    var content string
+    var createdAt time.Time
+    var editedAt sql.NullTime
+    err = tx.QueryRow(`SELECT content, created_at, edited_at FROM comments WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+        commentId, userId).Scan(&content, &createdAt, &editedAt)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("no comment found with the specified id that belongs to the user")
+    }
+    if err != nil {
+        return err
+    }
+    if content == comment.Content {
+        return nil
+    }
+
+    // The replaced content was written when the comment was created or last edited
+    writtenAt := createdAt
+    if editedAt.Valid {
+        writtenAt = editedAt.Time
+    }
+    _, err = tx.Exec(`INSERT INTO comment_revisions (comment_id, content, created_at) VALUES (?, ?, ?)`, commentId, content, writtenAt)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(`UPDATE comments SET content = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?`, comment.Content, commentId)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// GetCommentRevisions returns the previous versions of the comment, newest first.
+func (r *CommentRepository) GetCommentRevisions(commentID int) ([]model.CommentRevision, error) {
+    rows, err := r.db.Query(`SELECT id, comment_id, content, created_at, replaced_at FROM comment_revisions
+    WHERE comment_id = ? ORDER BY id DESC`, commentID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    revisions := []model.CommentRevision{}
+    for rows.Next() {
+        var revision model.CommentRevision
+        if err := rows.Scan(&revision.Id, &revision.CommentID, &revision.Content, &revision.CreatedAt, &revision.ReplacedAt); err != nil {
+            return nil, err
+        }
+        revisions = append(revisions, revision)
+    }
+    return revisions, rows.Err()
 }
 
 func (r *CommentRepository) GetAllPostComments(id int) ([]model.Comment, error) {
-    query := `SELECT * FROM comments WHERE post_id = ?`
+    query := `SELECT ` + commentColumns + ` FROM comments WHERE post_id = ?`
     rows, err := r.db.Query(query, id)
     if err != nil {
         return nil, err
@@ -80,8 +260,8 @@ func (r *CommentRepository) GetAllPostComments(id int) ([]model.Comment, error)
 
     var comments []model.Comment
     for rows.Next() {
-        var comment model.Comment
-        if err := rows.Scan(&comment.Id, &comment.Content, &comment.CreatedAt, &comment.PostID, &comment.UserID); err != nil {
+        comment, err := scanComment(rows)
+        if err != nil {
             return nil, err
         }
         comments = append(comments, comment)
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidVerificationToken is returned by ConsumeToken when the token doesn't exist or has expired.
+var ErrInvalidVerificationToken = errors.New("email verification token is invalid or has expired")
+
+// EmailVerificationRepository stores email verification tokens. Only the SHA-256 hash of a token is stored (see util.HashToken).
+type EmailVerificationRepository struct {
+	db *sql.DB
+}
+
+func NewEmailVerificationRepository(db *sql.DB) *EmailVerificationRepository {
+	return &EmailVerificationRepository{db: db}
+}
+
+// CreateToken stores a new token for the user. Only the latest link of a user works,
+// and expired tokens of all users are purged on the way.
+func (r *EmailVerificationRepository) CreateToken(userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM email_verification_tokens WHERE user_id = ? OR julianday(expires_at) < julianday('now')`, userID)
+	if err != nil {
+		fmt.Println("Error deleting old email verification tokens")
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO email_verification_tokens (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting email verification token into database")
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLastTokenCreatedAt returns when the latest token of the user was created, or the zero time if there is none.
+func (r *EmailVerificationRepository) GetLastTokenCreatedAt(userID int) (time.Time, error) {
+	var createdAt sql.NullTime
+	err := r.db.QueryRow(`SELECT created_at FROM email_verification_tokens WHERE user_id = ? ORDER BY id DESC LIMIT 1`, userID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return createdAt.Time, err
+}
+
+// ConsumeToken marks the email address of the token's user as verified, deletes the token and returns the user ID.
+func (r *EmailVerificationRepository) ConsumeToken(tokenHash string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM email_verification_tokens
+		WHERE token_hash = ? AND julianday(expires_at) > julianday('now')`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM email_verification_tokens WHERE user_id = ?`, userID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET verified_at = ? WHERE id = ? AND verified_at IS NULL`, time.Now(), userID); err != nil {
+		fmt.Println("Error marking email as verified")
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LoginAttemptRepository writes the login_attempts audit table.
+type LoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// RecordLoginAttempt stores a login attempt. A zero UserID is stored as NULL.
+func (r *LoginAttemptRepository) RecordLoginAttempt(attempt model.LoginAttempt) error {
+	var userID sql.NullInt64
+	if attempt.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(attempt.UserID), Valid: true}
+	}
+	_, err := r.db.Exec(`INSERT INTO login_attempts (username, user_id, ip_address, user_agent, success, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		attempt.Username, userID, attempt.IPAddress, attempt.UserAgent, attempt.Success, attempt.Reason, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting login attempt into database")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LoginThrottleRepository is the SQLite implementation of ratelimit.Store.
+// It keeps the login rate limiting state in the login_throttles table so it survives restarts.
+type LoginThrottleRepository struct {
+	db *sql.DB
+}
+
+func NewLoginThrottleRepository(db *sql.DB) *LoginThrottleRepository {
+	return &LoginThrottleRepository{db: db}
+}
+
+func (r *LoginThrottleRepository) Get(key string) (model.LoginThrottle, error) {
+	var throttle model.LoginThrottle
+	var lastFailureAt, blockedUntil sql.NullTime
+	err := r.db.QueryRow(`SELECT key, failures, last_failure_at, blocked_until FROM login_throttles WHERE key = ?`, key).Scan(
+		&throttle.Key, &throttle.Failures, &lastFailureAt, &blockedUntil)
+	if err == sql.ErrNoRows {
+		return model.LoginThrottle{Key: key}, nil
+	}
+	if err != nil {
+		fmt.Println("Error getting login throttle from database")
+		return model.LoginThrottle{}, err
+	}
+	throttle.LastFailureAt = lastFailureAt.Time
+	throttle.BlockedUntil = blockedUntil.Time
+	return throttle, nil
+}
+
+func (r *LoginThrottleRepository) Save(throttle model.LoginThrottle) error {
+	_, err := r.db.Exec(`INSERT INTO login_throttles (key, failures, last_failure_at, blocked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at, blocked_until = excluded.blocked_until`,
+		throttle.Key, throttle.Failures, nullTime(throttle.LastFailureAt), nullTime(throttle.BlockedUntil))
+	if err != nil {
+		fmt.Println("Error saving login throttle in database")
+		return err
+	}
+	return nil
+}
+
+func (r *LoginThrottleRepository) Delete(key string) error {
+	_, err := r.db.Exec(`DELETE FROM login_throttles WHERE key = ?`, key)
+	return err
+}
+
+func (r *LoginThrottleRepository) DeleteStale(window time.Duration) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM login_throttles
+		WHERE julianday(last_failure_at) < julianday('now', ?)
+		AND (blocked_until IS NULL OR julianday(blocked_until) < julianday('now'))`,
+		fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaRepository stores uploaded images and what they are used for.
+// A media row is referenced by e.g. a user's avatar through media_references (ref_type, ref_id).
+type MediaRepository struct {
+	db *sql.DB
+}
+
+func NewMediaRepository(db *sql.DB) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+const mediaColumns = `media.id, media.owner_id, media.hash, media.file_name, media.content_type, media.width, media.height, media.size_bytes, media.thumbnail_sizes, media.created_at`
+
+func scanMedia(row interface{ Scan(...interface{}) error }) (model.Media, error) {
+	var media model.Media
+	var thumbnailSizes string
+	err := row.Scan(&media.Id, &media.OwnerID, &media.Hash, &media.FileName, &media.ContentType, &media.Width, &media.Height, &media.SizeBytes, &thumbnailSizes, &media.CreatedAt)
+	if err != nil {
+		return model.Media{}, err
+	}
+	media.ThumbnailSizes = []int{}
+	for _, size := range strings.Split(thumbnailSizes, ",") {
+		if n, err := strconv.Atoi(size); err == nil {
+			media.ThumbnailSizes = append(media.ThumbnailSizes, n)
+		}
+	}
+	return media, nil
+}
+
+// CreateMedia stores an uploaded image and returns it with its ID.
+func (r *MediaRepository) CreateMedia(media model.Media) (model.Media, error) {
+	sizes := make([]string, len(media.ThumbnailSizes))
+	for i, size := range media.ThumbnailSizes {
+		sizes[i] = strconv.Itoa(size)
+	}
+	media.CreatedAt = time.Now()
+	result, err := r.db.Exec(`INSERT INTO media (owner_id, hash, file_name, content_type, width, height, size_bytes, thumbnail_sizes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		media.OwnerID, media.Hash, media.FileName, media.ContentType, media.Width, media.Height, media.SizeBytes, strings.Join(sizes, ","), media.CreatedAt)
+	if err != nil {
+		fmt.Println("Error inserting media into database")
+		return model.Media{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return model.Media{}, err
+	}
+	media.Id = int(id)
+	return media, nil
+}
+
+// GetMediaByID returns sql.ErrNoRows if the media doesn't exist.
+func (r *MediaRepository) GetMediaByID(mediaID int) (model.Media, error) {
+	return scanMedia(r.db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, mediaID))
+}
+
+// GetMediaByReference returns the media referenced by e.g. a post, in upload order.
+func (r *MediaRepository) GetMediaByReference(refType string, refID int) ([]model.Media, error) {
+	rows, err := r.db.Query(`SELECT `+mediaColumns+` FROM media
+		WHERE id IN (SELECT media_id FROM media_references WHERE ref_type = ? AND ref_id = ?)
+		ORDER BY id`, refType, refID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []model.Media{}
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+// GetMediaByReferences returns the media of several e.g. posts at once, by their ID and in upload order.
+func (r *MediaRepository) GetMediaByReferences(refType string, refIDs []int) (map[int][]model.Media, error) {
+	media := make(map[int][]model.Media)
+	if len(refIDs) == 0 {
+		return media, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(refIDs)), ", ")
+	args := []interface{}{refType}
+	for _, id := range refIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`SELECT media_references.ref_id, `+mediaColumns+` FROM media
+		JOIN media_references ON media_references.media_id = media.id
+		WHERE media_references.ref_type = ? AND media_references.ref_id IN (`+placeholders+`)
+		ORDER BY media.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var refID int
+		m, err := scanMedia(prefixedScanner{rows, &refID})
+		if err != nil {
+			return nil, err
+		}
+		media[refID] = append(media[refID], m)
+	}
+	return media, rows.Err()
+}
+
+// prefixedScanner scans the first column into prefix and passes the rest on, so scanMedia can read joined rows.
+type prefixedScanner struct {
+	rows   *sql.Rows
+	prefix interface{}
+}
+
+func (s prefixedScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append([]interface{}{s.prefix}, dest...)...)
+}
+
+// AddReference records that the media is used by e.g. a post. Adding the same reference twice does nothing.
+func (r *MediaRepository) AddReference(mediaID int, refType string, refID int) error {
+	_, err := r.db.Exec(`INSERT OR IGNORE INTO media_references (media_id, ref_type, ref_id) VALUES (?, ?, ?)`, mediaID, refType, refID)
+	return err
+}
+
+// RemoveReferences removes the references of e.g. a deleted post. The media rows are kept, see DeleteUnreferencedMedia.
+func (r *MediaRepository) RemoveReferences(refType string, refID int) error {
+	_, err := r.db.Exec(`DELETE FROM media_references WHERE ref_type = ? AND ref_id = ?`, refType, refID)
+	return err
+}
+
+// DeleteUnreferencedMedia deletes the media row if nothing references it anymore and reports whether it did.
+func (r *MediaRepository) DeleteUnreferencedMedia(mediaID int) (bool, error) {
+	result, err := r.db.Exec(`DELETE FROM media WHERE id = ? AND NOT EXISTS (SELECT 1 FROM media_references WHERE media_id = ?)`, mediaID, mediaID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// CountMediaByHash counts the media rows stored in the file with the hash. Identical uploads share a file.
+func (r *MediaRepository) CountMediaByHash(hash string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM media WHERE hash = ?`, hash).Scan(&count)
+	return count, err
+}
+
+// ReplaceURLPrefix rewrites the stored avatar and post image URLs that start with oldPrefix,
+// after the images moved to another store. It returns how many URLs changed.
+func (r *MediaRepository) ReplaceURLPrefix(oldPrefix, newPrefix string) (int64, error) {
+	var total int64
+	for _, column := range []struct{ table, name string }{{"users", "avatar_url"}, {"posts", "image_url"}} {
+		result, err := r.db.Exec(`UPDATE `+column.table+` SET `+column.name+` = ? || substr(`+column.name+`, ?)
+			WHERE substr(`+column.name+`, 1, ?) = ?`, newPrefix, len(oldPrefix)+1, len(oldPrefix), oldPrefix)
+		if err != nil {
+			return total, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += rowsAffected
+	}
+	return total, nil
+}
+
+// GetReferencesByHash returns what uses the media stored in the file with the hash, including media nothing uses.
+func (r *MediaRepository) GetReferencesByHash(hash string) ([]model.MediaReference, error) {
+	rows, err := r.db.Query(`SELECT media.id, media.owner_id, COALESCE(media_references.ref_type, ''), COALESCE(media_references.ref_id, 0)
+		FROM media LEFT JOIN media_references ON media_references.media_id = media.id
+		WHERE media.hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	references := []model.MediaReference{}
+	for rows.Next() {
+		var reference model.MediaReference
+		if err := rows.Scan(&reference.MediaID, &reference.OwnerID, &reference.RefType, &reference.RefID); err != nil {
+			return nil, err
+		}
+		references = append(references, reference)
+	}
+	return references, rows.Err()
+}
+
+// GetLegacyAvatarOwners returns the users whose avatar_url points at the file name. Avatars uploaded before
+// the media table existed were named after the username and have no media row.
+func (r *MediaRepository) GetLegacyAvatarOwners(fileName string) ([]int, error) {
+	rows, err := r.db.Query(`SELECT id FROM users WHERE substr(avatar_url, -length(?) - 1) = '/' || ?`, fileName, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
@@ -52,6 +52,36 @@ func (r *NotificationRepository) CreateNotification(notification model.Notificat
 	return lastInsertID, nil
 }
 
+// MergeNotification keeps one unread notification per user, type and subject (RefType and RefID): if there is one,
+// its message is replaced and it moves to the top, otherwise the notification is added. This way e.g. a popular post
+// makes one "X and 99 others reacted" notification instead of a hundred.
+func (r *NotificationRepository) MergeNotification(notification model.Notification) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE notifications SET message = ?, created_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND type = ? AND ref_type = ? AND ref_id = ? AND is_read = FALSE`,
+		notification.Message, notification.UserId, notification.Type, notification.RefType, notification.RefID)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		_, err = tx.Exec(`INSERT INTO notifications (user_id, type, message, is_read, ref_type, ref_id) VALUES (?, ?, ?, FALSE, ?, ?)`,
+			notification.UserId, notification.Type, notification.Message, notification.RefType, notification.RefID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // GetNotificationByID retrieves a specific notification by its ID from the database.
 func (r *NotificationRepository) GetNotificationByID(id int) (model.Notification, error) {
 	query := `SELECT * FROM notification WHERE id = ?`
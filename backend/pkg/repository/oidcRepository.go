@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrIdentityTaken is returned by LinkIdentity when the provider account is already linked to a user.
+var ErrIdentityTaken = errors.New("this account is already linked to a user")
+
+// OIDCRepository stores the provider accounts linked to users and the pending logins and registrations
+// of the OpenID Connect login. State and registration tokens are stored hashed (see util.HashToken).
+type OIDCRepository struct {
+	db *sql.DB
+}
+
+func NewOIDCRepository(db *sql.DB) *OIDCRepository {
+	return &OIDCRepository{db: db}
+}
+
+// GetIdentity returns the identity of the provider account, or sql.ErrNoRows if it isn't linked to any user.
+func (r *OIDCRepository) GetIdentity(provider, subject string) (model.UserIdentity, error) {
+	return scanIdentity(r.db.QueryRow(`SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities WHERE provider = ? AND subject = ?`, provider, subject))
+}
+
+// GetIdentitiesByUserID lists the provider accounts linked to the user.
+func (r *OIDCRepository) GetIdentitiesByUserID(userID int) ([]model.UserIdentity, error) {
+	rows, err := r.db.Query(`SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []model.UserIdentity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func scanIdentity(row interface{ Scan(...interface{}) error }) (model.UserIdentity, error) {
+	var identity model.UserIdentity
+	var email sql.NullString
+	var lastLoginAt sql.NullTime
+	err := row.Scan(&identity.Id, &identity.UserID, &identity.Provider, &identity.Subject, &email, &identity.CreatedAt, &lastLoginAt)
+	if err != nil {
+		return model.UserIdentity{}, err
+	}
+	identity.Email = email.String
+	identity.LastLoginAt = lastLoginAt.Time
+	return identity, nil
+}
+
+// LinkIdentity links the provider account to the user. It returns ErrIdentityTaken if it is linked already.
+func (r *OIDCRepository) LinkIdentity(identity model.UserIdentity) error {
+	_, err := r.db.Exec(`INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)`,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email, time.Now())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrIdentityTaken
+		}
+		fmt.Println("Error inserting user identity into database")
+		return err
+	}
+	return nil
+}
+
+// UpdateIdentityLogin records a login with the identity and the email address the provider reported this time.
+func (r *OIDCRepository) UpdateIdentityLogin(id int, email string) error {
+	_, err := r.db.Exec(`UPDATE user_identities SET email = ?, last_login_at = ? WHERE id = ?`, email, time.Now(), id)
+	return err
+}
+
+// UnlinkIdentity deletes the identity with the ID if it belongs to the user, otherwise it returns sql.ErrNoRows.
+func (r *OIDCRepository) UnlinkIdentity(id, userID int) error {
+	result, err := r.db.Exec(`DELETE FROM user_identities WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateLoginState stores a login that is redirected to the provider. Expired states are purged on the way.
+func (r *OIDCRepository) CreateLoginState(stateHash string, state model.OIDCLoginState) error {
+	if _, err := r.db.Exec(`DELETE FROM oidc_login_states WHERE julianday(expires_at) < julianday('now')`); err != nil {
+		return err
+	}
+	var linkUserID sql.NullInt64
+	if state.LinkUserID != 0 {
+		linkUserID = sql.NullInt64{Int64: int64(state.LinkUserID), Valid: true}
+	}
+	_, err := r.db.Exec(`INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, link_user_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		stateHash, state.Provider, state.Nonce, state.CodeVerifier, linkUserID, state.ExpiresAt, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting OIDC login state into database")
+		return err
+	}
+	return nil
+}
+
+// ConsumeLoginState deletes and returns the unexpired login state with the hash, or sql.ErrNoRows.
+// A state can only come back from the provider once.
+func (r *OIDCRepository) ConsumeLoginState(stateHash string) (model.OIDCLoginState, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.OIDCLoginState{}, err
+	}
+	defer tx.Rollback()
+
+	var state model.OIDCLoginState
+	var linkUserID sql.NullInt64
+	err = tx.QueryRow(`SELECT id, provider, nonce, code_verifier, link_user_id, expires_at FROM oidc_login_states
+		WHERE state_hash = ? AND julianday(expires_at) > julianday('now')`, stateHash).Scan(
+		&state.Id, &state.Provider, &state.Nonce, &state.CodeVerifier, &linkUserID, &state.ExpiresAt)
+	if err != nil {
+		return model.OIDCLoginState{}, err
+	}
+	state.LinkUserID = int(linkUserID.Int64)
+
+	if _, err := tx.Exec(`DELETE FROM oidc_login_states WHERE id = ?`, state.Id); err != nil {
+		return model.OIDCLoginState{}, err
+	}
+	return state, tx.Commit()
+}
+
+// CreateRegistration stores the first login of an unknown provider account until the user finishes registering.
+// Expired registrations are purged on the way.
+func (r *OIDCRepository) CreateRegistration(tokenHash string, registration model.OIDCRegistration) error {
+	if _, err := r.db.Exec(`DELETE FROM oidc_registrations WHERE julianday(expires_at) < julianday('now')`); err != nil {
+		return err
+	}
+	prefill := registration.Prefill
+	_, err := r.db.Exec(`INSERT INTO oidc_registrations (token_hash, provider, subject, email, email_verified, username,
+		first_name, last_name, date_of_birth, avatar_url, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		tokenHash, registration.Provider, registration.Subject, registration.Email, registration.EmailVerified, prefill.Username,
+		prefill.FirstName, prefill.LastName, prefill.DOB, prefill.AvatarURL, registration.ExpiresAt, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting OIDC registration into database")
+		return err
+	}
+	return nil
+}
+
+// GetRegistration returns the unexpired registration with the token hash, or sql.ErrNoRows.
+func (r *OIDCRepository) GetRegistration(tokenHash string) (model.OIDCRegistration, error) {
+	var registration model.OIDCRegistration
+	var email, username, firstName, lastName, dob, avatarURL sql.NullString
+	err := r.db.QueryRow(`SELECT id, provider, subject, email, email_verified, username, first_name, last_name,
+		date_of_birth, avatar_url, expires_at FROM oidc_registrations
+		WHERE token_hash = ? AND julianday(expires_at) > julianday('now')`, tokenHash).Scan(
+		&registration.Id, &registration.Provider, &registration.Subject, &email, &registration.EmailVerified,
+		&username, &firstName, &lastName, &dob, &avatarURL, &registration.ExpiresAt)
+	if err != nil {
+		return model.OIDCRegistration{}, err
+	}
+	registration.Email = email.String
+	registration.Prefill = model.RegistrationData{
+		Username:  username.String,
+		Email:     email.String,
+		FirstName: firstName.String,
+		LastName:  lastName.String,
+		DOB:       dob.String,
+		AvatarURL: avatarURL.String,
+	}
+	return registration, nil
+}
+
+// CompleteRegistration creates the user, links the provider account to them and deletes the registration,
+// all in one transaction. The email address is marked verified if verified is true.
+// It returns ErrDuplicateUser if the username or email is taken, and ErrIdentityTaken if the provider account
+// was linked to someone else in the meantime.
+func (r *OIDCRepository) CompleteRegistration(registration model.OIDCRegistration, data model.RegistrationData, verified bool) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var verifiedAt sql.NullTime
+	if verified {
+		verifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	result, err := tx.Exec(`INSERT INTO users (username, email, password, first_name, last_name, date_of_birth, avatar_url, about_me, verified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		data.Username, data.Email, data.Password, data.FirstName, data.LastName, data.DOB, data.AvatarURL, data.About, verifiedAt)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, ErrDuplicateUser
+		}
+		fmt.Println("Error inserting user into database")
+		return 0, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, registration.Provider, registration.Subject, registration.Email, time.Now(), time.Now())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, ErrIdentityTaken
+		}
+		fmt.Println("Error inserting user identity into database")
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM oidc_registrations WHERE id = ?`, registration.Id); err != nil {
+		return 0, err
+	}
+	return int(userID), tx.Commit()
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidResetToken is returned by ConsumeToken when the token doesn't exist, has expired or was already used.
+var ErrInvalidResetToken = errors.New("password reset token is invalid or has expired")
+
+// PasswordResetRepository stores password reset tokens. Only the SHA-256 hash of a token is stored (see util.HashToken).
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// CreateToken stores a new token for the user. Requesting a new link invalidates the previous ones,
+// and expired tokens of all users are purged on the way.
+func (r *PasswordResetRepository) CreateToken(userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM password_reset_tokens WHERE user_id = ? OR julianday(expires_at) < julianday('now')`, userID)
+	if err != nil {
+		fmt.Println("Error deleting old password reset tokens")
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)`,
+		userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting password reset token into database")
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLastTokenCreatedAt returns when the latest token of the user was created, or the zero time if there is none.
+func (r *PasswordResetRepository) GetLastTokenCreatedAt(userID int) (time.Time, error) {
+	var createdAt sql.NullTime
+	err := r.db.QueryRow(`SELECT created_at FROM password_reset_tokens WHERE user_id = ? ORDER BY id DESC LIMIT 1`, userID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return createdAt.Time, err
+}
+
+// ConsumeToken marks the token as used and returns the ID of its user.
+// The update only matches an unused, unexpired token, so a token can be used once even by concurrent requests.
+func (r *PasswordResetRepository) ConsumeToken(tokenHash string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND julianday(expires_at) > julianday('now')`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`, time.Now(), tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if updated == 0 {
+		return 0, ErrInvalidResetToken
+	}
+	return userID, tx.Commit()
+}
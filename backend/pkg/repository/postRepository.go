@@ -4,6 +4,7 @@ import (
 	"backend/pkg/model"
 	"database/sql"
 	"fmt"
+	"strings"
 )
 
 type PostRepository struct {
@@ -14,10 +15,21 @@ func NewPostRepository(db *sql.DB) *PostRepository {
     return &PostRepository{db: db}
 }
 
+// CreatePost inserts the post, and for custom posts the users who can see it, in one transaction.
 func (r *PostRepository) CreatePost(post model.CreatePostRequest, userID int) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	query := `INSERT INTO posts (user_id, title, group_id, content, image_url, privacy_setting) 
 	VALUES (?, ?, ?, ?, ?, ?)`
-	result, err := r.db.Exec(query, userID, post.GroupID, post.Title, post.Content, post.ImageURL, post.PrivacySetting)
+	var groupID interface{}
+	if post.GroupID != 0 {
+		groupID = post.GroupID
+	}
+	result, err := tx.Exec(query, userID, post.Title, groupID, post.Content, post.ImageURL, post.PrivacySetting)
 	if err != nil {
 		fmt.Println("Error inserting post into database: ", err)
 		return 0, err
@@ -25,40 +37,139 @@ func (r *PostRepository) CreatePost(post model.CreatePostRequest, userID int) (i
 	lastInsertID, err := result.LastInsertId()
 	if err != nil {
 		fmt.Println("Error getting last inserted post id")
+		return 0, err
+	}
+	if post.PrivacySetting == "custom" {
+		if err := insertPostAudience(tx, int(lastInsertID), post.Audience); err != nil {
+			return 0, err
+		}
+	}
+	return lastInsertID, tx.Commit()
+}
+
+// insertPostAudience adds the users who can see a custom post.
+func insertPostAudience(tx *sql.Tx, postID int, userIDs []int) error {
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO post_audience (post_id, user_id) VALUES (?, ?)`, postID, userID); err != nil {
+			fmt.Println("Error inserting post audience into database: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// IsInPostAudience reports whether the user was picked to see the custom post.
+func (r *PostRepository) IsInPostAudience(postID, userID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM post_audience WHERE post_id = ? AND user_id = ?)`, postID, userID).Scan(&exists)
+	return exists, err
+}
+
+// GetPostAudiences returns the users who can see each of the custom posts, by post ID.
+func (r *PostRepository) GetPostAudiences(postIDs []int) (map[int][]int, error) {
+	audiences := make(map[int][]int)
+	if len(postIDs) == 0 {
+		return audiences, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(postIDs)), ", ")
+	args := []interface{}{}
+	for _, id := range postIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`SELECT post_id, user_id FROM post_audience WHERE post_id IN (`+placeholders+`) ORDER BY user_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID, userID int
+		if err := rows.Scan(&postID, &userID); err != nil {
+			return nil, err
+		}
+		audiences[postID] = append(audiences[postID], userID)
+	}
+	return audiences, rows.Err()
+}
+
+// postColumns are the columns scanPost reads, in order.
+const postColumns = `posts.id, posts.user_id, posts.group_id, posts.title, posts.content, posts.image_url, posts.privacy_setting, posts.created_at,
+    posts.edited_at, (SELECT COUNT(*) FROM post_revisions WHERE post_revisions.post_id = posts.id)`
+
+func scanPost(row interface{ Scan(...interface{}) error }) (model.Post, error) {
+	var post model.Post
+	var groupID sql.NullInt64
+	var content, imageURL sql.NullString
+	var editedAt sql.NullTime
+	if err := row.Scan(&post.Id, &post.UserID, &groupID, &post.Title, &content, &imageURL, &post.PrivacySetting, &post.CreatedAt, &editedAt, &post.RevisionCount); err != nil {
+		return model.Post{}, err
+	}
+	post.GroupID = int(groupID.Int64)
+	post.Content = content.String
+	post.ImageURL = imageURL.String
+	if editedAt.Valid {
+		post.EditedAt = &editedAt.Time
+	}
+	return post, nil
+}
+
+// postWithAuthorColumns are the columns scanPostWithAuthor reads, posts joined with users.
+const postWithAuthorColumns = postColumns + `, users.username, users.avatar_url`
+
+func scanPostWithAuthor(row interface{ Scan(...interface{}) error }) (model.Post, error) {
+	var username string
+	var avatarURL sql.NullString
+	post, err := scanPost(suffixedScanner{row, []interface{}{&username, &avatarURL}})
+	if err != nil {
+		return model.Post{}, err
 	}
-	return lastInsertID, nil
+	post.Author = &model.Author{Id: post.UserID, Username: username, AvatarURL: avatarURL.String}
+	return post, nil
 }
 
+// GetPostWithAuthor is GetPostByID with the post's author filled in.
+func (r *PostRepository) GetPostWithAuthor(postID int) (model.Post, error) {
+	return scanPostWithAuthor(r.db.QueryRow(`SELECT ` + postWithAuthorColumns + ` FROM posts JOIN users ON users.id = posts.user_id WHERE posts.id = ?`, postID))
+}
+
+// GetPostByID returns sql.ErrNoRows if the post doesn't exist.
+func (r *PostRepository) GetPostByID(postID int) (model.Post, error) {
+	return scanPost(r.db.QueryRow(`SELECT ` + postColumns + ` FROM posts WHERE id = ?`, postID))
+}
 
-// GetAllPostsWithUserIDAccess retrieves all posts with the given user ID access.
-// It queries the database to fetch posts that meet the following conditions:
-// - Posts with the specified user ID
+// GetFeed returns a page of the posts the user can see, newest first, with their authors:
+// - Posts by the user
 // - Posts with privacy setting set to 'public'
 // - Posts with privacy setting set to 'private' and the user is a friend (status = 'accepted')
-// The function returns a slice of model.Post and an error if any occurred during the query.
-func (r *PostRepository) GetAllPostsWithUserIDAccess(userID int) ([]model.Post, error) {
+// - Posts with privacy setting set to 'custom' and the user is in the post's audience
+// - Posts in the groups the user is a member or the owner of
+// Posts are ordered by created_at and then id, so pages are stable; after is the last post of the previous page, or nil.
+func (r *PostRepository) GetFeed(userID int, after *model.Cursor, limit int) ([]model.Post, error) {
     query := `
-    SELECT posts.* 
+    SELECT ` + postWithAuthorColumns + `
     FROM posts 
-    WHERE posts.user_id = ? 
-    OR posts.privacy_setting = 'public' 
-    OR (posts.privacy_setting = 'private' AND posts.user_id IN (
-        SELECT user_id1 FROM friends WHERE user_id2 = ? AND status = 'accepted'
-        UNION
-        SELECT user_id2 FROM friends WHERE user_id1 = ? AND status = 'accepted'
-    ))
-    `
+    JOIN users ON users.id = posts.user_id
+    WHERE ` + postVisibleTo
+    args := postVisibleToArgs(userID)
+    if after != nil {
+        // datetime() compares timestamps the same way whatever format they were stored in
+        createdAt := after.CreatedAt.UTC().Format("2006-01-02 15:04:05")
+        query += ` AND (datetime(posts.created_at) < ? OR (datetime(posts.created_at) = ? AND posts.id < ?))`
+        args = append(args, createdAt, createdAt, after.ID)
+    }
+    query += ` ORDER BY datetime(posts.created_at) DESC, posts.id DESC LIMIT ?`
+    args = append(args, limit)
 
-    rows, err := r.db.Query(query, userID, userID, userID)
+    rows, err := r.db.Query(query, args...)
     if err != nil {
-        return []model.Post{}, err
+        return nil, err
     }
     defer rows.Close()
 
-    var posts []model.Post
+    posts := []model.Post{}
     for rows.Next() {
-        var post model.Post
-        if err := rows.Scan(&post.Id, &post.UserID, &post.Title, &post.Content, &post.ImageURL, &post.CreatedAt); err != nil {
+        post, err := scanPostWithAuthor(rows)
+        if err != nil {
             return nil, err
         }
         posts = append(posts, post)
@@ -70,8 +181,40 @@ func (r *PostRepository) GetAllPostsWithUserIDAccess(userID int) ([]model.Post,
     return posts, nil
 }
 
+// postVisibleTo is the condition for the posts a user can see, the rules of handler.Visibility.CanViewPost:
+// their own posts, public posts, friends' private posts, custom posts they are in the audience of and posts in
+// the groups they are a member or the owner of. Its parameters are postVisibleToArgs.
+const postVisibleTo = `(posts.user_id = ? 
+    OR (posts.group_id IS NULL AND (
+        posts.privacy_setting = 'public' 
+        OR (posts.privacy_setting = 'private' AND posts.user_id IN (
+            SELECT user_id1 FROM friends WHERE user_id2 = ? AND status = 'accepted'
+            UNION
+            SELECT user_id2 FROM friends WHERE user_id1 = ? AND status = 'accepted'
+        ))
+        OR (posts.privacy_setting = 'custom' AND posts.id IN (
+            SELECT post_id FROM post_audience WHERE user_id = ?
+        ))
+    ))
+    OR posts.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?)
+    OR posts.group_id IN (SELECT id FROM groups WHERE creator_id = ?))`
+
+func postVisibleToArgs(userID int) []interface{} {
+    return []interface{}{userID, userID, userID, userID, userID, userID}
+}
+
+// suffixedScanner passes the first columns on and scans the rest into suffix, so scanPost can read joined rows.
+type suffixedScanner struct {
+    row    interface{ Scan(...interface{}) error }
+    suffix []interface{}
+}
+
+func (s suffixedScanner) Scan(dest ...interface{}) error {
+    return s.row.Scan(append(dest, s.suffix...)...)
+}
+
 func (r *PostRepository) GetAllUserPosts(userID int) ([]model.Post, error) {
-    query := `SELECT * FROM posts WHERE user_id = ? AND group_id IS NULL`
+    query := `SELECT ` + postColumns + ` FROM posts WHERE user_id = ? AND group_id IS NULL`
     rows, err := r.db.Query(query, userID)
     if err != nil {
         return nil, err
@@ -80,8 +223,45 @@ func (r *PostRepository) GetAllUserPosts(userID int) ([]model.Post, error) {
 
     var posts []model.Post
     for rows.Next() {
-        var post model.Post
-        if err := rows.Scan(&post.Id, &post.UserID, &post.Title, &post.Content, &post.ImageURL, &post.CreatedAt); err != nil {
+        post, err := scanPost(rows)
+        if err != nil {
+            return nil, err
+        }
+        posts = append(posts, post)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return posts, nil
+}
+
+// GetUserPostsVisibleTo returns the posts on the user's profile that the viewer can see: public posts,
+// private posts if they are friends and custom posts the viewer was picked for.
+func (r *PostRepository) GetUserPostsVisibleTo(userID, viewerID int) ([]model.Post, error) {
+    query := `
+    SELECT ` + postColumns + ` 
+    FROM posts 
+    WHERE posts.user_id = ? AND posts.group_id IS NULL AND (
+        posts.privacy_setting = 'public'
+        OR (posts.privacy_setting = 'private' AND EXISTS (
+            SELECT 1 FROM friends WHERE status = 'accepted'
+            AND ((user_id1 = posts.user_id AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = posts.user_id))
+        ))
+        OR (posts.privacy_setting = 'custom' AND posts.id IN (
+            SELECT post_id FROM post_audience WHERE user_id = ?
+        ))
+    )
+    `
+    rows, err := r.db.Query(query, userID, viewerID, viewerID, viewerID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var posts []model.Post
+    for rows.Next() {
+        post, err := scanPost(rows)
+        if err != nil {
             return nil, err
         }
         posts = append(posts, post)
@@ -93,7 +273,7 @@ func (r *PostRepository) GetAllUserPosts(userID int) ([]model.Post, error) {
 }
 
 func (r *PostRepository) GetAllUserPublicPosts(userID int) ([]model.Post, error) {
-    query := `SELECT * FROM posts WHERE user_id = ? AND privacy_setting = 'public' AND group_id IS NULL`
+    query := `SELECT ` + postColumns + ` FROM posts WHERE user_id = ? AND privacy_setting = 'public' AND group_id IS NULL`
     rows, err := r.db.Query(query, userID)
     if err != nil {
         return nil, err
@@ -102,8 +282,8 @@ func (r *PostRepository) GetAllUserPublicPosts(userID int) ([]model.Post, error)
 
     var posts []model.Post
     for rows.Next() {
-        var post model.Post
-        if err := rows.Scan(&post.Id, &post.UserID, &post.Title, &post.Content, &post.ImageURL, &post.CreatedAt); err != nil {
+        post, err := scanPost(rows)
+        if err != nil {
             return nil, err
         }
         posts = append(posts, post)
@@ -114,74 +294,145 @@ func (r *PostRepository) GetAllUserPublicPosts(userID int) ([]model.Post, error)
     return posts, nil
 }
 
-func (r *PostRepository) DeletePost(postID int, userID int) error {
+// DeletePost deletes the user's post with its audience, revisions and comments, and the reactions and revisions
+// of the comments. It returns the IDs of the deleted comments, whose images the caller releases.
+func (r *PostRepository) DeletePost(postID int, userID int) ([]int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `DELETE FROM posts WHERE id = ? AND user_id = ?`
-	result, err := r.db.Exec(query, postID, userID)
+	result, err := tx.Exec(query, postID, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no post found with the specified id that belongs to the user")
+		return nil, fmt.Errorf("no post found with the specified id that belongs to the user")
 	}
-	return nil
+	if _, err := tx.Exec(`DELETE FROM post_audience WHERE post_id = ?`, postID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM post_revisions WHERE post_id = ?`, postID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM comments WHERE post_id = ?`, postID)
+	if err != nil {
+		return nil, err
+	}
+	commentIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		commentIDs = append(commentIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, query := range []string{
+		`DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)`,
+		`DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)`,
+		`DELETE FROM comments WHERE post_id = ?`,
+	} {
+		if _, err := tx.Exec(query, postID); err != nil {
+			return nil, err
+		}
+	}
+	return commentIDs, tx.Commit()
 }
 
+// UpdatePost changes the post and replaces its audience, which is only kept for custom posts.
+// If the title, content, image or privacy setting change, the previous version is kept as a revision.
 func (r *PostRepository) UpdatePost(postID int, userID int, request model.UpdatePostRequest) error {
-    query := `UPDATE posts SET title = ?, content = ?, image_url = ?, privacy_setting = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
-
-    result, err := r.db.Exec(query, request.Title, request.Content, request.ImageURL, request.PrivacySetting, postID, userID)
+    tx, err := r.db.Begin()
     if err != nil {
-        return err // Handle the error appropriately
+        return err
     }
+    defer tx.Rollback()
 
-    // Check if a row was actually updated
-    rowsAffected, err := result.RowsAffected()
+    var current model.Post
+    var content, imageURL sql.NullString
+    var editedAt sql.NullTime
+    err = tx.QueryRow(`SELECT title, content, image_url, privacy_setting, created_at, edited_at FROM posts WHERE id = ? AND user_id = ?`,
+        postID, userID).Scan(&current.Title, &content, &imageURL, &current.PrivacySetting, &current.CreatedAt, &editedAt)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("no post found with the specified id that belongs to the user")
+    }
     if err != nil {
-        return err // Handle the error appropriately
+        return err
     }
-    if rowsAffected == 0 {
-        return fmt.Errorf("no post found with the specified id that belongs to the user or no update was needed")
+
+    edited := current.Title != request.Title || content.String != request.Content || current.PrivacySetting != request.PrivacySetting
+    if edited {
+        // The replaced version was written when the post was created or last edited
+        writtenAt := current.CreatedAt
+        if editedAt.Valid {
+            writtenAt = editedAt.Time
+        }
+        _, err = tx.Exec(`INSERT INTO post_revisions (post_id, version, title, content, image_url, privacy_setting, created_at)
+            SELECT ?, COUNT(*) + 1, ?, ?, ?, ?, ? FROM post_revisions WHERE post_id = ?`,
+            postID, current.Title, content, imageURL, current.PrivacySetting, writtenAt, postID)
+        if err != nil {
+            return err
+        }
+        _, err = tx.Exec(`UPDATE posts SET title = ?, content = ?, privacy_setting = ?, edited_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+            request.Title, request.Content, request.PrivacySetting, postID)
+    } else {
+        _, err = tx.Exec(`UPDATE posts SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, postID)
+    }
+    if err != nil {
+        return err
     }
 
-    return nil
+    if _, err := tx.Exec(`DELETE FROM post_audience WHERE post_id = ?`, postID); err != nil {
+        return err
+    }
+    if request.PrivacySetting == "custom" {
+        if err := insertPostAudience(tx, postID, request.Audience); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
 }
 
-func (r *PostRepository) GetPostsByGroupID(groupID int) ([]model.Post, error) {
-    query := `SELECT * FROM posts WHERE group_id = ?`
-    rows, err := r.db.Query(query, groupID)
+// GetPostRevisions returns the earlier versions of the post, newest first.
+func (r *PostRepository) GetPostRevisions(postID int) ([]model.PostRevision, error) {
+    rows, err := r.db.Query(`SELECT id, post_id, version, title, content, image_url, privacy_setting, created_at, replaced_at
+    FROM post_revisions WHERE post_id = ? ORDER BY version DESC`, postID)
     if err != nil {
         return nil, err
     }
     defer rows.Close()
 
-    var posts []model.Post
+    revisions := []model.PostRevision{}
     for rows.Next() {
-        var post model.Post
-        if err := rows.Scan(&post.Id, &post.UserID, &post.Title, &post.Content, &post.ImageURL, &post.CreatedAt); err != nil {
+        var revision model.PostRevision
+        var content, imageURL sql.NullString
+        if err := rows.Scan(&revision.Id, &revision.PostID, &revision.Version, &revision.Title, &content, &imageURL,
+            &revision.PrivacySetting, &revision.CreatedAt, &revision.ReplacedAt); err != nil {
             return nil, err
         }
-        posts = append(posts, post)
-    }
-    if err := rows.Err(); err != nil {
-        return nil, err
+        revision.Content = content.String
+        revision.ImageURL = imageURL.String
+        revisions = append(revisions, revision)
     }
-    return posts, nil
+    return revisions, rows.Err()
 }
 
-func (r *PostRepository) GetPostsByUserGroups(userID int) ([]model.Post, error) {
-    query := `
-    SELECT posts.* 
-    FROM posts 
-    JOIN group_members ON posts.group_id = group_members.group_id
-    WHERE group_members.user_id = ?
-    `
-
-    rows, err := r.db.Query(query, userID)
+func (r *PostRepository) GetPostsByGroupID(groupID int) ([]model.Post, error) {
+    query := `SELECT ` + postColumns + ` FROM posts WHERE group_id = ?`
+    rows, err := r.db.Query(query, groupID)
     if err != nil {
         return nil, err
     }
@@ -189,14 +440,13 @@ func (r *PostRepository) GetPostsByUserGroups(userID int) ([]model.Post, error)
 
     var posts []model.Post
     for rows.Next() {
-        var post model.Post
-        if err := rows.Scan(&post.Id, &post.UserID, &post.GroupID, &post.Title, &post.Content, &post.ImageURL, &post.CreatedAt); err != nil {
+        post, err := scanPost(rows)
+        if err != nil {
             return nil, err
         }
         posts = append(posts, post)
     }
-
-    if err = rows.Err(); err != nil {
+    if err := rows.Err(); err != nil {
         return nil, err
     }
     return posts, nil
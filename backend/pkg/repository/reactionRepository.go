@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"strings"
+)
+
+type ReactionRepository struct {
+	db *sql.DB
+}
+
+func NewReactionRepository(db *sql.DB) *ReactionRepository {
+	return &ReactionRepository{db: db}
+}
+
+// ToggleReaction gives the post or comment the user's reaction. Sending the same reaction again removes it, and
+// a different one replaces it. It reports whether the reaction is new, i.e. the user hadn't reacted before.
+func (r *ReactionRepository) ToggleReaction(userID int, targetType string, targetID int, reaction string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(`SELECT reaction FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+		userID, targetType, targetID).Scan(&current)
+	added := false
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(`INSERT INTO reactions (user_id, target_type, target_id, reaction) VALUES (?, ?, ?, ?)`,
+			userID, targetType, targetID, reaction)
+		added = true
+	case err != nil:
+		return false, err
+	case current == reaction:
+		_, err = tx.Exec(`DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?`, userID, targetType, targetID)
+	default:
+		_, err = tx.Exec(`UPDATE reactions SET reaction = ? WHERE user_id = ? AND target_type = ? AND target_id = ?`,
+			reaction, userID, targetType, targetID)
+	}
+	if err != nil {
+		return false, err
+	}
+	return added, tx.Commit()
+}
+
+// GetReactionSummaries counts the reactions of the posts or comments by kind, with the viewer's reaction, by target ID.
+// Targets without reactions are left out.
+func (r *ReactionRepository) GetReactionSummaries(targetType string, targetIDs []int, viewerID int) (map[int]model.ReactionSummary, error) {
+	summaries := make(map[int]model.ReactionSummary)
+	if len(targetIDs) == 0 {
+		return summaries, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(targetIDs)), ", ")
+	args := []interface{}{viewerID, targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`SELECT target_id, reaction, COUNT(*), MAX(user_id = ?) FROM reactions
+		WHERE target_type = ? AND target_id IN (`+placeholders+`)
+		GROUP BY target_id, reaction`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetID, count int
+		var reaction string
+		var mine bool
+		if err := rows.Scan(&targetID, &reaction, &count, &mine); err != nil {
+			return nil, err
+		}
+		summary, ok := summaries[targetID]
+		if !ok {
+			summary.Counts = make(map[string]int)
+		}
+		summary.Counts[reaction] = count
+		summary.Total += count
+		if mine {
+			summary.MyReaction = reaction
+		}
+		summaries[targetID] = summary
+	}
+	return summaries, rows.Err()
+}
+
+// CountReactors returns how many users reacted to the post or comment, leaving out the user (its author).
+func (r *ReactionRepository) CountReactors(targetType string, targetID int, exceptUserID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM reactions WHERE target_type = ? AND target_id = ? AND user_id != ?`,
+		targetType, targetID, exceptUserID).Scan(&count)
+	return count, err
+}
+
+// DeleteReactions removes the reactions of a deleted post or comment.
+func (r *ReactionRepository) DeleteReactions(targetType string, targetID int) error {
+	_, err := r.db.Exec(`DELETE FROM reactions WHERE target_type = ? AND target_id = ?`, targetType, targetID)
+	return err
+}
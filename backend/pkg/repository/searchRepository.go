@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// SearchRepository searches the FTS5 indexes of users, posts, groups and events (see migration 000035).
+type SearchRepository struct {
+	db *sql.DB
+}
+
+func NewSearchRepository(db *sql.DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+const (
+	// SQLite wraps the matching words in these control characters, which markHighlights turns into <mark> tags
+	// after escaping the text
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+	// maxSearchTerms bounds the cost of a query, further words are ignored
+	maxSearchTerms = 10
+)
+
+var highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightEnd, "</mark>")
+
+func markHighlights(text string) string {
+	return highlightReplacer.Replace(html.EscapeString(text))
+}
+
+// SearchQuery turns what the user typed into an FTS5 query that matches rows containing all of its words,
+// or words starting with them. It returns "" if there are no words to search for.
+// Splitting on everything but letters and digits, like the unicode61 tokenizer, leaves no FTS5 syntax.
+func SearchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// The search queries select the columns search reads: id, title, snippet, the author's id, username and
+// avatar_url (posts), the avatar_url (users) and the bm25 rank, which is lower for better matches.
+// Title columns weigh more than the others.
+
+// SearchUsers matches usernames and names. The avatar is left out if the viewer can't see it (private profiles
+// of users who aren't friends).
+func (r *SearchRepository) SearchUsers(query string, viewerID int, limit int) ([]model.SearchResult, error) {
+	return r.search("user", `
+    SELECT users.id, highlight(users_fts, 0, ?, ?),
+        highlight(users_fts, 1, ?, ?) || ' ' || highlight(users_fts, 2, ?, ?),
+        NULL, NULL, NULL,
+        CASE WHEN users.profile = 'public' OR users.id = ? OR EXISTS (
+            SELECT 1 FROM friends WHERE status = 'accepted'
+            AND ((user_id1 = users.id AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = users.id))
+        ) THEN users.avatar_url END,
+        bm25(users_fts, 10.0, 5.0, 5.0) AS score
+    FROM users_fts
+    JOIN users ON users.id = users_fts.rowid
+    WHERE users_fts MATCH ?
+    ORDER BY score LIMIT ?`,
+		highlightStart, highlightEnd, highlightStart, highlightEnd, highlightStart, highlightEnd,
+		viewerID, viewerID, viewerID, query, limit)
+}
+
+// SearchPosts matches post titles and content, of the posts the viewer can see (see postVisibleTo).
+func (r *SearchRepository) SearchPosts(query string, viewerID int, limit int) ([]model.SearchResult, error) {
+	args := []interface{}{highlightStart, highlightEnd, highlightStart, highlightEnd, query}
+	args = append(args, postVisibleToArgs(viewerID)...)
+	args = append(args, limit)
+	return r.search("post", `
+    SELECT posts.id, highlight(posts_fts, 0, ?, ?), snippet(posts_fts, 1, ?, ?, '…', 24),
+        users.id, users.username, users.avatar_url,
+        NULL,
+        bm25(posts_fts, 10.0, 1.0) AS score
+    FROM posts_fts
+    JOIN posts ON posts.id = posts_fts.rowid
+    JOIN users ON users.id = posts.user_id
+    WHERE posts_fts MATCH ? AND `+postVisibleTo+`
+    ORDER BY score LIMIT ?`, args...)
+}
+
+// SearchGroups matches group titles and descriptions. Groups are listed for everyone.
+func (r *SearchRepository) SearchGroups(query string, limit int) ([]model.SearchResult, error) {
+	return r.search("group", `
+    SELECT groups.id, highlight(groups_fts, 0, ?, ?), snippet(groups_fts, 1, ?, ?, '…', 24),
+        NULL, NULL, NULL, NULL,
+        bm25(groups_fts, 10.0, 1.0) AS score
+    FROM groups_fts
+    JOIN groups ON groups.id = groups_fts.rowid
+    WHERE groups_fts MATCH ?
+    ORDER BY score LIMIT ?`,
+		highlightStart, highlightEnd, highlightStart, highlightEnd, query, limit)
+}
+
+// SearchEvents matches event titles and locations. Events are listed for everyone, like GET /events.
+func (r *SearchRepository) SearchEvents(query string, limit int) ([]model.SearchResult, error) {
+	return r.search("event", `
+    SELECT events.id, highlight(events_fts, 0, ?, ?), highlight(events_fts, 1, ?, ?),
+        NULL, NULL, NULL, NULL,
+        bm25(events_fts, 10.0, 2.0) AS score
+    FROM events_fts
+    JOIN events ON events.id = events_fts.rowid
+    WHERE events_fts MATCH ?
+    ORDER BY score LIMIT ?`,
+		highlightStart, highlightEnd, highlightStart, highlightEnd, query, limit)
+}
+
+func (r *SearchRepository) search(resultType string, query string, args ...interface{}) ([]model.SearchResult, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []model.SearchResult{}
+	for rows.Next() {
+		result := model.SearchResult{Type: resultType}
+		var snippet, authorUsername, authorAvatarURL, avatarURL sql.NullString
+		var authorID sql.NullInt64
+		var rank float64
+		if err := rows.Scan(&result.Id, &result.Title, &snippet, &authorID, &authorUsername, &authorAvatarURL, &avatarURL, &rank); err != nil {
+			return nil, err
+		}
+		result.Title = markHighlights(result.Title)
+		result.Snippet = markHighlights(snippet.String)
+		if authorID.Valid {
+			result.Author = &model.Author{Id: int(authorID.Int64), Username: authorUsername.String, AvatarURL: authorAvatarURL.String}
+		}
+		result.AvatarURL = avatarURL.String
+		result.Score = -rank
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
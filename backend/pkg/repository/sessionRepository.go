@@ -1,34 +1,102 @@
 package repository
 
 import (
+	"backend/pkg/config"
 	"backend/pkg/model"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 )
 
+// ErrSessionExpired is returned by ValidateSession when the session exists but its expiresAt has passed.
+var ErrSessionExpired = errors.New("session expired")
+
 type SessionRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	config config.SessionConfig
+}
+
+func NewSessionRepository(db *sql.DB, cfg config.SessionConfig) *SessionRepository {
+	return &SessionRepository{db: db, config: cfg}
+}
+
+// sessionColumns is the column list scanned by scanSession.
+const sessionColumns = `id, sessionToken, userID, userAgent, ipAddress, createdAt, lastSeenAt, expiresAt, absoluteExpiresAt, rememberMe`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (model.Session, error) {
+	var session model.Session
+	var absoluteExpiresAt sql.NullTime
+	err := row.Scan(&session.Id, &session.SessionToken, &session.UserID, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &absoluteExpiresAt, &session.RememberMe)
+	if err != nil {
+		return model.Session{}, err
+	}
+	session.AbsoluteExpiresAt = session.ExpiresAt
+	if absoluteExpiresAt.Valid {
+		session.AbsoluteExpiresAt = absoluteExpiresAt.Time
+	}
+	return session, nil
 }
 
-func NewSessionRepository(db *sql.DB) *SessionRepository {
-	return &SessionRepository{db: db}
+// timeouts returns the idle and absolute timeout for a session.
+func (r *SessionRepository) timeouts(rememberMe bool) (time.Duration, time.Duration) {
+	if rememberMe {
+		return r.config.RememberMeIdleTimeout, r.config.RememberMeAbsoluteTimeout
+	}
+	return r.config.IdleTimeout, r.config.AbsoluteTimeout
 }
 
-func (r *SessionRepository) StoreSessionInDB(sessionToken string, userID int) {
-	expiresAt := time.Now().Add(30 * time.Minute)
-	_, err := r.db.Exec(`INSERT OR REPLACE INTO sessions (sessionToken, userID, expiresAt)
-	VALUES (?, ?, ?)`, sessionToken, userID, expiresAt)
+// StoreSessionInDB stores a new session for session.UserID. A user can have any number of sessions,
+// one for every device they are logged in on. The expiry is set from the configured timeouts,
+// depending on session.RememberMe, and the stored session is returned.
+func (r *SessionRepository) StoreSessionInDB(session model.Session) (model.Session, error) {
+	idleTimeout, absoluteTimeout := r.timeouts(session.RememberMe)
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	session.AbsoluteExpiresAt = now.Add(absoluteTimeout)
+	session.ExpiresAt = minTime(now.Add(idleTimeout), session.AbsoluteExpiresAt)
+
+	result, err := r.db.Exec(`INSERT INTO sessions (sessionToken, userID, expiresAt, absoluteExpiresAt, rememberMe, userAgent, ipAddress, createdAt, lastSeenAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, session.SessionToken, session.UserID, session.ExpiresAt, session.AbsoluteExpiresAt, session.RememberMe,
+		session.UserAgent, session.IPAddress, session.CreatedAt, session.LastSeenAt)
 	if err != nil {
 		fmt.Println("Error inserting session into database: ", err)
-		log.Fatalf("Error inserting session into database: %v", err)
+		return model.Session{}, err
+	}
+	sessionID, err := result.LastInsertId()
+	if err != nil {
+		return model.Session{}, err
 	}
+	session.Id = int(sessionID)
+	return session, nil
+}
+
+// ExtendSession slides the expiry of an active session forward by the idle timeout, capped at its absolute expiry.
+// Sessions are only written when the last extension is older than the refresh interval;
+// otherwise the session is returned unchanged.
+func (r *SessionRepository) ExtendSession(session model.Session) (model.Session, error) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < r.config.RefreshInterval {
+		return session, nil
+	}
+	idleTimeout, _ := r.timeouts(session.RememberMe)
+	expiresAt := minTime(now.Add(idleTimeout), session.AbsoluteExpiresAt)
+
+	_, err := r.db.Exec(`UPDATE sessions SET lastSeenAt = ?, expiresAt = ? WHERE sessionToken = ?`, now, expiresAt, session.SessionToken)
+	if err != nil {
+		fmt.Println("Error extending session: ", err)
+		return session, err
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = expiresAt
+	return session, nil
 }
 
 func (r *SessionRepository) GetSessionBySessionToken(sessionToken string) (model.Session, error) {
-	var session model.Session
-	err := r.db.QueryRow(`SELECT userID, expiresAt FROM sessions WHERE sessionToken = ?`, sessionToken).Scan(&session.UserID, &session.ExpiresAt)
+	session, err := scanSession(r.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE sessionToken = ?`, sessionToken))
 	if err != nil {
 		fmt.Println("Error querying session1: ", err)
 		return model.Session{}, err
@@ -36,6 +104,19 @@ func (r *SessionRepository) GetSessionBySessionToken(sessionToken string) (model
 	return session, nil
 }
 
+// ValidateSession returns the session for the token if it exists and has not expired.
+// It returns sql.ErrNoRows for an unknown token and ErrSessionExpired for an expired one.
+func (r *SessionRepository) ValidateSession(sessionToken string) (model.Session, error) {
+	session, err := r.GetSessionBySessionToken(sessionToken)
+	if err != nil {
+		return model.Session{}, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return model.Session{}, ErrSessionExpired
+	}
+	return session, nil
+}
+
 func (r *SessionRepository) GetUserIDFromSessionToken(sessionToken string) (int, error) {
 	var userID int
 	err := r.db.QueryRow(`SELECT userID FROM sessions WHERE sessionToken = ?`, sessionToken).Scan(&userID)
@@ -45,3 +126,111 @@ func (r *SessionRepository) GetUserIDFromSessionToken(sessionToken string) (int,
 	}
 	return userID, nil
 }
+
+// GetSessionsByUserID returns all sessions of the user that have not expired, most recently used first.
+func (r *SessionRepository) GetSessionsByUserID(userID int) ([]model.Session, error) {
+	rows, err := r.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE userID = ? ORDER BY lastSeenAt DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession deletes the session with the token, invalidating it on the server side.
+func (r *SessionRepository) DeleteSession(sessionToken string) error {
+	_, err := r.db.Exec(`DELETE FROM sessions WHERE sessionToken = ?`, sessionToken)
+	return err
+}
+
+// DeleteUserSession deletes the session with the given ID if it belongs to the user.
+// It returns the token of the deleted session, or sql.ErrNoRows if the user has no such session.
+func (r *SessionRepository) DeleteUserSession(sessionID, userID int) (string, error) {
+	var sessionToken string
+	err := r.db.QueryRow(`SELECT sessionToken FROM sessions WHERE id = ? AND userID = ?`, sessionID, userID).Scan(&sessionToken)
+	if err != nil {
+		return "", err
+	}
+	err = r.DeleteSession(sessionToken)
+	if err != nil {
+		return "", err
+	}
+	return sessionToken, nil
+}
+
+// DeleteOtherUserSessions deletes every session of the user except the one with keepToken.
+// It returns the tokens of the deleted sessions.
+func (r *SessionRepository) DeleteOtherUserSessions(userID int, keepToken string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT sessionToken FROM sessions WHERE userID = ? AND sessionToken != ?`, userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []string{}
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if err := r.DeleteSession(token); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// DeleteExpiredSessions purges all sessions whose expiresAt has passed and returns how many were deleted.
+func (r *SessionRepository) DeleteExpiredSessions() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM sessions WHERE julianday(expiresAt) < julianday('now')`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RunExpiredSessionSweeper calls DeleteExpiredSessions right away and then every interval.
+// It blocks, so run it in its own goroutine.
+func (r *SessionRepository) RunExpiredSessionSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := r.DeleteExpiredSessions()
+		if err != nil {
+			log.Println("Error purging expired sessions: ", err)
+		} else if deleted > 0 {
+			log.Println("Purged expired sessions: ", deleted)
+		}
+		<-ticker.C
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"backend/pkg/model"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TwoFactorRepository stores TOTP secrets, recovery codes and pending MFA logins.
+// Recovery codes and challenge tokens are stored hashed (see util.HashToken).
+type TwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+// GetTOTP returns the TOTP secret of the user, or sql.ErrNoRows if they never set up 2FA.
+func (r *TwoFactorRepository) GetTOTP(userID int) (model.TOTP, error) {
+	var totp model.TOTP
+	var enabledAt sql.NullTime
+	err := r.db.QueryRow(`SELECT user_id, secret, enabled_at, last_used_step FROM user_totp WHERE user_id = ?`, userID).Scan(
+		&totp.UserID, &totp.Secret, &enabledAt, &totp.LastUsedStep)
+	if err != nil {
+		return model.TOTP{}, err
+	}
+	totp.EnabledAt = enabledAt.Time
+	return totp, nil
+}
+
+// IsEnabled reports whether the user has 2FA turned on.
+func (r *TwoFactorRepository) IsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL)`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// SavePendingSecret stores a new secret that isn't enabled until EnableTOTP confirms it, replacing an earlier pending one.
+func (r *TwoFactorRepository) SavePendingSecret(userID int, secret string) error {
+	_, err := r.db.Exec(`INSERT INTO user_totp (user_id, secret, enabled_at, last_used_step, created_at) VALUES (?, ?, NULL, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, enabled_at = NULL, last_used_step = 0, created_at = excluded.created_at`,
+		userID, secret, time.Now())
+	if err != nil {
+		fmt.Println("Error saving TOTP secret in database")
+		return err
+	}
+	return nil
+}
+
+// EnableTOTP turns 2FA on with the confirmed step as the last used one and replaces the recovery codes of the user.
+func (r *TwoFactorRepository) EnableTOTP(userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`UPDATE user_totp SET enabled_at = ?, last_used_step = ? WHERE user_id = ?`, now, step, userID)
+	if err != nil {
+		fmt.Println("Error enabling TOTP")
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`, userID, hash, now)
+		if err != nil {
+			fmt.Println("Error inserting recovery code into database")
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of the step was accepted. It returns false if a code of that or a later step
+// was already used, so the same code can't log in twice.
+func (r *TwoFactorRepository) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := r.db.Exec(`UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used. It returns false if there is no such code.
+func (r *TwoFactorRepository) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := r.db.Exec(`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left.
+func (r *TwoFactorRepository) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteTOTP turns 2FA off by deleting the secret, recovery codes and pending challenges of the user.
+func (r *TwoFactorRepository) DeleteTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM user_totp WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM mfa_challenges WHERE user_id = ?`,
+	} {
+		if _, err := tx.Exec(query, userID); err != nil {
+			fmt.Println("Error deleting TOTP data")
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateChallenge stores a pending MFA login. Expired challenges of all users are purged on the way.
+func (r *TwoFactorRepository) CreateChallenge(tokenHash string, challenge model.MFAChallenge) error {
+	if _, err := r.db.Exec(`DELETE FROM mfa_challenges WHERE julianday(expires_at) < julianday('now')`); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`INSERT INTO mfa_challenges (token_hash, user_id, remember_me, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		tokenHash, challenge.UserID, challenge.RememberMe, challenge.ExpiresAt, time.Now())
+	if err != nil {
+		fmt.Println("Error inserting MFA challenge into database")
+		return err
+	}
+	return nil
+}
+
+// GetChallenge returns the unexpired challenge with the token hash, or sql.ErrNoRows.
+func (r *TwoFactorRepository) GetChallenge(tokenHash string) (model.MFAChallenge, error) {
+	var challenge model.MFAChallenge
+	err := r.db.QueryRow(`SELECT id, user_id, remember_me, attempts, expires_at FROM mfa_challenges
+		WHERE token_hash = ? AND julianday(expires_at) > julianday('now')`, tokenHash).Scan(
+		&challenge.Id, &challenge.UserID, &challenge.RememberMe, &challenge.Attempts, &challenge.ExpiresAt)
+	return challenge, err
+}
+
+// IncrementChallengeAttempts counts a wrong code for the challenge.
+func (r *TwoFactorRepository) IncrementChallengeAttempts(id int) error {
+	_, err := r.db.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ?`, id)
+	return err
+}
+
+func (r *TwoFactorRepository) DeleteChallenge(id int) error {
+	_, err := r.db.Exec(`DELETE FROM mfa_challenges WHERE id = ?`, id)
+	return err
+}
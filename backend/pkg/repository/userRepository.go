@@ -3,9 +3,16 @@ package repository
 import (
 	"backend/pkg/model"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrDuplicateUser is returned when a username or email address is already taken by another user.
+var ErrDuplicateUser = errors.New("username or email is already taken")
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -16,12 +23,35 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 
 // # Data access layer, interacts with db
 
-func (r *UserRepository) GetUserByEmailOrNickname(emailOrNickname string) (model.User, error) {
-	query := "SELECT * FROM users WHERE email = ? OR username = ? LIMIT 1"
+const userColumns = "id, username, email, password, first_name, last_name, date_of_birth, avatar_url, about_me, profile, created_at, updated_at, verified_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (model.User, error) {
 	var user model.User
-	err := r.db.QueryRow(query, emailOrNickname, emailOrNickname).Scan(
+	var verifiedAt sql.NullTime
+	err := row.Scan(
 		&user.Id, &user.Username, &user.Email, &user.Password, &user.FirstName, &user.LastName,
-		&user.DOB, &user.AvatarURL, &user.About, &user.Profile, &user.CreatedAt, &user.UpdatedAt)
+		&user.DOB, &user.AvatarURL, &user.About, &user.Profile, &user.CreatedAt, &user.UpdatedAt, &verifiedAt)
+	if err != nil {
+		return model.User{}, err
+	}
+	user.VerifiedAt = verifiedAt.Time
+	return user, nil
+}
+
+func (r *UserRepository) GetUserByEmailOrNickname(emailOrNickname string) (model.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE email = ? OR username = ? LIMIT 1"
+	user, err := scanUser(r.db.QueryRow(query, emailOrNickname, emailOrNickname))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			fmt.Println("User not found in database")
+		}
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+func (r *UserRepository) GetUserByID(id int) (model.User, error) {
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			fmt.Println("User not found in database")
@@ -31,6 +61,13 @@ func (r *UserRepository) GetUserByEmailOrNickname(emailOrNickname string) (model
 	return user, nil
 }
 
+// IsEmailVerified reports whether the user has confirmed their email address.
+func (r *UserRepository) IsEmailVerified(id int) (bool, error) {
+	var verified bool
+	err := r.db.QueryRow("SELECT verified_at IS NOT NULL FROM users WHERE id = ?", id).Scan(&verified)
+	return verified, err
+}
+
 func (r *UserRepository) RegisterUser(data model.RegistrationData) (int64, error) {
 	result, err := r.db.Exec("INSERT INTO users (username, email, password, first_name, last_name, date_of_birth, avatar_url, about_me) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
 		data.Username, data.Email, data.Password, data.FirstName, data.LastName, data.DOB, data.AvatarURL, data.About)
@@ -59,16 +96,64 @@ func (r *UserRepository) GetUserProfileByID(id int) (model.Profile, error) {
 	return profile, nil
 }
 
-func (r *UserRepository) UpdateUserProfile(id int, data model.RegistrationData) error {
-	_, err := r.db.Exec("UPDATE users SET username = ?, email = ?, password = ?, first_name = ?, last_name = ?, date_of_birth = ?, avatar_url = ?, about_me = ?, profile = ? WHERE id = ?",
-		data.Username, data.Email, data.Password, data.FirstName, data.LastName, data.DOB, data.AvatarURL, data.About, data.ProfileSetting, id)
+// UpdateUserProfile updates the fields of the user that are set in the update and leaves the others unchanged.
+// Changing the email address makes it unverified again. It returns ErrDuplicateUser if the new username or email is taken.
+func (r *UserRepository) UpdateUserProfile(id int, update model.ProfileUpdate) error {
+	columns := []struct {
+		name  string
+		value *string
+	}{
+		{"username", update.Username},
+		{"email", update.Email},
+		{"first_name", update.FirstName},
+		{"last_name", update.LastName},
+		{"date_of_birth", update.DOB},
+		{"avatar_url", update.AvatarURL},
+		{"about_me", update.About},
+		{"profile", update.ProfileSetting},
+	}
+
+	set := []string{}
+	args := []interface{}{}
+	if update.Email != nil {
+		// Evaluated with the old row, so it compares the old and the new address
+		set = append(set, "verified_at = CASE WHEN email = ? THEN verified_at ELSE NULL END")
+		args = append(args, *update.Email)
+	}
+	for _, column := range columns {
+		if column.value != nil {
+			set = append(set, column.name+" = ?")
+			args = append(args, *column.value)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	set = append(set, "updated_at = CURRENT_TIMESTAMP")
+	args = append(args, id)
+
+	_, err := r.db.Exec("UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = ?", args...)
 	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrDuplicateUser
+		}
 		fmt.Println("Error updating user profile in database")
 		return err
 	}
 	return nil
 }
 
+// UpdatePassword stores a new bcrypt hashed password for the user.
+func (r *UserRepository) UpdatePassword(id int, hashedPassword string) error {
+	_, err := r.db.Exec("UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", hashedPassword, id)
+	if err != nil {
+		fmt.Println("Error updating user password in database")
+		return err
+	}
+	return nil
+}
+
 func (r *UserRepository) GetAllUsersExcludeRequestingUserAndFriends(userID int) ([]model.UserList, error) {
 	query := `
     SELECT u.id, u.username, u.first_name, u.last_name, u.avatar_url 
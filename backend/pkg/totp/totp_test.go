@@ -0,0 +1,67 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidate(t *testing.T) {
+	// The 6 digit codes are the last digits of the 8 digit codes of RFC 6238, appendix B
+	at := time.Unix(1111111109, 0)
+	step := at.Unix() / 30
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		t        time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"rfc vector 59", rfcSecret, "287082", time.Unix(59, 0), 1, true},
+		{"rfc vector 1111111109", rfcSecret, "081804", at, step, true},
+		{"rfc vector 1234567890", rfcSecret, "005924", time.Unix(1234567890, 0), 1234567890 / 30, true},
+		{"rfc vector 2000000000", rfcSecret, "279037", time.Unix(2000000000, 0), 2000000000 / 30, true},
+		{"previous step is accepted", rfcSecret, "081804", at.Add(30 * time.Second), step, true},
+		{"next step is accepted", rfcSecret, "081804", at.Add(-30 * time.Second), step, true},
+		{"two steps late is rejected", rfcSecret, "081804", at.Add(60 * time.Second), 0, false},
+		{"two steps early is rejected", rfcSecret, "081804", at.Add(-60 * time.Second), 0, false},
+		{"spaces are ignored", rfcSecret, " 081 804 ", at, step, true},
+		{"lowercase secret", strings.ToLower(rfcSecret), "081804", at, step, true},
+		{"wrong code", rfcSecret, "081805", at, 0, false},
+		{"too short", rfcSecret, "81804", at, 0, false},
+		{"too long", rfcSecret, "0081804", at, 0, false},
+		{"empty", rfcSecret, "", at, 0, false},
+		{"invalid secret", "not base32!", "081804", at, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(tt.secret, tt.code, tt.t)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate(%q, %q) = %d, %v, want %d, %v", tt.secret, tt.code, gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+	now := time.Now()
+	code := generate(key, now.Unix()/30)
+	if _, ok := Validate(secret, code, now); !ok {
+		t.Errorf("code %s of a generated secret is not valid", code)
+	}
+}
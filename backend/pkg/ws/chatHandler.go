@@ -1,6 +1,7 @@
 package ws
 
 import (
+	"backend/pkg/middleware"
 	"backend/pkg/repository"
 	"fmt"
 	"log"
@@ -10,10 +11,14 @@ import (
 type ChatHandler struct {
 	ChatRepo    *ChatRepository
 	SessionRepo *repository.SessionRepository
+	// APITokenRepo authenticates connections of scripts and bots with an API token that has the "chat" scope
+	APITokenRepo *repository.APITokenRepository
+	// VerificationPolicy decides whether users with an unverified email address may send messages
+	VerificationPolicy *middleware.VerificationPolicy
 }
 
-func NewChatHandler(chatRepo *ChatRepository, sessionRepo *repository.SessionRepository) *ChatHandler {
-	return &ChatHandler{ChatRepo: chatRepo, SessionRepo: sessionRepo}
+func NewChatHandler(chatRepo *ChatRepository, sessionRepo *repository.SessionRepository, apiTokenRepo *repository.APITokenRepository, verificationPolicy *middleware.VerificationPolicy) *ChatHandler {
+	return &ChatHandler{ChatRepo: chatRepo, SessionRepo: sessionRepo, APITokenRepo: apiTokenRepo, VerificationPolicy: verificationPolicy}
 }
 func (h *ChatHandler) FetchChatHistory(c *Client, recipientID int, page int) {
 
@@ -31,6 +36,19 @@ func (h *ChatHandler) FetchChatHistory(c *Client, recipientID int, page int) {
 }
 
 func (h *ChatHandler) SendMessage(messageData map[string]interface{}, c *Client) {
+	allowed, err := h.VerificationPolicy.Allows(c.ID, "chat")
+	if err != nil {
+		log.Printf("Error checking email verification: %v", err)
+		return
+	}
+	if !allowed {
+		c.Conn.WriteJSON(map[string]interface{}{
+			"action":  "error",
+			"content": "Please verify your email address first",
+		})
+		return
+	}
+
 	message, ok := messageData["content"].(string)
 	if !ok {
 		log.Printf("Invalid message format: %v", messageData)
@@ -49,7 +67,7 @@ func (h *ChatHandler) SendMessage(messageData map[string]interface{}, c *Client)
 		}
 	}
 
-	err := h.ChatRepo.StoreMessage(c.ID, recipientID, message)
+	err = h.ChatRepo.StoreMessage(c.ID, recipientID, message)
 	if err != nil {
 		log.Print("Error while storing message to database")
 		return
@@ -13,6 +13,11 @@ type Client struct {
 	ID       int
 	Username string
 	Online   bool
+	// SessionToken of the session the connection was opened with. It is set before the client is registered and
+	// never changes, so the hub can read it while readPump extends the session.
+	SessionToken string
+	// APITokenID is the API token the connection was opened with instead of a session, 0 for sessions
+	APITokenID int
 }
 
 type Hub struct {
@@ -28,7 +33,15 @@ type Hub struct {
 	// Unregister requests from the clients.
 	Unregister chan *Client
 
+	// Session tokens whose connections have to be closed, e.g. after logout.
+	CloseSession chan string
+
+	// IDs of API tokens whose connections have to be closed after the token was revoked.
+	CloseAPIToken chan int
+
 	ChatHandler *ChatHandler
+
+	upgrader websocket.Upgrader
 }
 
 type FetchMessage struct {
@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"backend/pkg/middleware"
+	"backend/pkg/model"
 	"backend/util"
 	"encoding/json"
 	"github.com/gorilla/websocket"
@@ -11,14 +13,27 @@ import (
 
 var newline = []byte{'\n'}
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// TODO: Remove after development
-		// Check if the origin is allowed
-		return r.Header.Get("Origin") == "http://localhost:3000"
-	},
+// newUpgrader returns an upgrader that only accepts websocket handshakes from the allowed origins.
+// Browsers send the session cookie with cross-site websocket handshakes, so this is what protects /ws from CSRF.
+// Handshakes with an API token are accepted from anywhere, since browsers can't add the Authorization header to them.
+func newUpgrader(allowedOrigins []string) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			if middleware.BearerToken(r) != "" {
+				return true
+			}
+			origin := r.Header.Get("Origin")
+			for _, allowed := range allowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			log.Println("Rejected websocket connection from origin: ", origin)
+			return false
+		},
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -26,7 +41,8 @@ var upgrader = websocket.Upgrader{
 // The application runs readPump in a per-connection goroutine. The application
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
-func (c *Client) readPump() {
+// The session the connection was opened with is only extended here, the zero session for API tokens.
+func (c *Client) readPump(session model.Session) {
 	defer func() {
 		c.Hub.DisconnectedUserWsAlert(c.ID)
 		c.Hub.Unregister <- c
@@ -43,6 +59,14 @@ func (c *Client) readPump() {
 			}
 			break
 		}
+		// Chat activity keeps the session alive like HTTP requests do
+		if c.APITokenID == 0 {
+			session, err = c.Hub.ChatHandler.SessionRepo.ExtendSession(session)
+			if err != nil {
+				log.Printf("Error extending session: %v", err)
+			}
+		}
+
 		// Assuming your messages are in JSON format
 		var messageData map[string]interface{}
 		if err := json.Unmarshal(message, &messageData); err != nil {
@@ -69,7 +93,7 @@ func (c *Client) readPump() {
 			pageInt, pageOK := messageData["page"].(float64)
 			if !userOK || !pageOK {
 				// Handle the error if any of these conversions fail
-				log.Printf("Invalid or missing parameters: userOK=%v, pageOK=%v, perPageOK=%v", userOK, pageOK)
+				log.Printf("Invalid or missing parameters: userOK=%v, pageOK=%v", userOK, pageOK)
 				continue
 			}
 			userInt, _ := strconv.Atoi(userStr)
@@ -120,25 +144,50 @@ func (c *Client) writePump() {
 }
 
 // ServeWs handles websocket requests from the peer.
+// Browsers authenticate with the session cookie, scripts and bots with an API token that has the "chat" scope.
 func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.ChatHandler.SessionRepo.GetUserIDFromSessionToken(util.GetSessionToken(r))
-	if err != nil {
-		log.Println("Error confirming authentication: ", err)
-		return
+	client := &Client{Hub: h, Send: make(chan []byte, 256), Online: true}
+	var session model.Session
+	if rawToken := middleware.BearerToken(r); rawToken != "" {
+		token, err := h.ChatHandler.APITokenRepo.ValidateToken(util.HashToken(rawToken))
+		if err != nil {
+			log.Println("Error confirming authentication: ", err)
+			middleware.WriteUnauthorized(w, "API token is invalid or has expired")
+			return
+		}
+		if !middleware.HasScope(token, "chat") {
+			http.Error(w, "The API token doesn't allow chat", http.StatusForbidden)
+			return
+		}
+		if err := h.ChatHandler.APITokenRepo.TouchToken(token, util.GetClientIP(r)); err != nil {
+			log.Println("Error updating API token: ", err)
+		}
+		client.ID = token.UserID
+		client.APITokenID = token.Id
+	} else {
+		var err error
+		session, err = h.ChatHandler.SessionRepo.ValidateSession(util.GetSessionToken(r))
+		if err != nil {
+			log.Println("Error confirming authentication: ", err)
+			middleware.WriteUnauthorized(w, "Session is invalid or has expired")
+			return
+		}
+		client.ID = session.UserID
+		client.SessionToken = session.SessionToken
 	}
-	log.Println("UserID ", userID, " connected")
+	log.Println("UserID ", client.ID, " connected")
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{Hub: h, Conn: conn, Send: make(chan []byte, 256), ID: userID, Online: true}
+	client.Conn = conn
 	h.Register <- client
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
-	go client.readPump()
+	go client.readPump(session)
 }
 
 func (h *Hub) NewUserWsAlert(newUserID int) {
@@ -1,12 +1,15 @@
 package ws
 
-func NewHub(chatHandler *ChatHandler) *Hub {
+func NewHub(chatHandler *ChatHandler, allowedOrigins []string) *Hub {
 	return &Hub{
-		Broadcast:   make(chan []byte),
-		Clients:     make(map[*Client]bool),
-		Register:    make(chan *Client),
-		Unregister:  make(chan *Client),
-		ChatHandler: chatHandler,
+		upgrader:      newUpgrader(allowedOrigins),
+		Broadcast:     make(chan []byte),
+		Clients:       make(map[*Client]bool),
+		Register:      make(chan *Client),
+		Unregister:    make(chan *Client),
+		CloseSession:  make(chan string),
+		CloseAPIToken: make(chan int),
+		ChatHandler:   chatHandler,
 	}
 }
 
@@ -20,6 +23,20 @@ func (h *Hub) Run() {
 				delete(h.Clients, client)
 				close(client.Send)
 			}
+		case sessionToken := <-h.CloseSession:
+			for client := range h.Clients {
+				if client.APITokenID == 0 && client.SessionToken == sessionToken {
+					delete(h.Clients, client)
+					close(client.Send)
+				}
+			}
+		case tokenID := <-h.CloseAPIToken:
+			for client := range h.Clients {
+				if client.APITokenID == tokenID {
+					delete(h.Clients, client)
+					close(client.Send)
+				}
+			}
 		case message := <-h.Broadcast:
 
 			for client := range h.Clients {
@@ -33,3 +50,17 @@ func (h *Hub) Run() {
 		}
 	}
 }
+
+// CloseSessionConnections closes every websocket connection that was opened with one of the session tokens.
+func (h *Hub) CloseSessionConnections(sessionTokens ...string) {
+	for _, sessionToken := range sessionTokens {
+		h.CloseSession <- sessionToken
+	}
+}
+
+// CloseAPITokenConnections closes every websocket connection that was opened with one of the API tokens.
+func (h *Hub) CloseAPITokenConnections(tokenIDs ...int) {
+	for _, tokenID := range tokenIDs {
+		h.CloseAPIToken <- tokenID
+	}
+}
@@ -2,16 +2,23 @@ package main
 
 import (
 	"backend/api"
+	"backend/pkg/config"
 	"backend/pkg/db/sqlite"
+	"backend/pkg/media"
+	"backend/pkg/repository"
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
 	mux := mux.NewRouter()
+	cfg := config.Load()
 	dbPath := "./pkg/db/database.db"
 	migrationsPath := "pkg/db/migrations/sqlite"
 
@@ -22,8 +29,45 @@ func main() {
 	}
 	defer db.Close()
 
-	api.Router(mux, db)
+	if len(os.Args) > 1 && os.Args[1] == "migrate-media" {
+		migrateMedia(db, cfg, os.Args[2:])
+		return
+	}
+
+	api.Router(mux, db, cfg)
 
 	fmt.Println("Server is running on http://localhost:8080")
 	http.ListenAndServe(":8080", nil)
 }
+
+// migrateMedia moves the uploaded images to another store and rewrites the stored image URLs:
+//
+//	go run . migrate-media -from local -to s3 [-delete]
+func migrateMedia(db *sql.DB, cfg config.Config, args []string) {
+	flags := flag.NewFlagSet("migrate-media", flag.ExitOnError)
+	from := flags.String("from", "local", "store to move the images from, local or s3")
+	to := flags.String("to", "s3", "store to move the images to, local or s3")
+	deleteSource := flags.Bool("delete", false, "delete the images from the old store after copying them")
+	flags.Parse(args)
+	if *from == *to {
+		log.Fatal("-from and -to must be different stores")
+	}
+
+	fromStore, err := media.NewStore(*from, cfg.Media)
+	if err != nil {
+		log.Fatal(err)
+	}
+	toStore, err := media.NewStore(*to, cfg.Media)
+	if err != nil {
+		log.Fatal(err)
+	}
+	copied, err := media.Migrate(fromStore, toStore, *deleteSource)
+	if err != nil {
+		log.Fatal("Migrating images failed after ", copied, " images: ", err)
+	}
+	updated, err := repository.NewMediaRepository(db).ReplaceURLPrefix(fromStore.URL(""), toStore.URL(""))
+	if err != nil {
+		log.Fatal("Rewriting image URLs failed: ", err)
+	}
+	fmt.Printf("Copied %d images and rewrote %d image URLs. Set MEDIA_STORE=%s to use the new store.\n", copied, updated, *to)
+}
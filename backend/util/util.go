@@ -1,15 +1,13 @@
 package util
 
 import (
-	"backend/pkg/model"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
 )
 
 func GenerateSessionToken() string {
@@ -21,35 +19,11 @@ func GenerateSessionToken() string {
 	return hex.EncodeToString(b)
 }
 
-func ImageSave(w http.ResponseWriter, r *http.Request, regData *model.RegistrationData) {
-
-	// 2. Extract the image file from the form data
-	file, _, err := r.FormFile("avatar")
-	if err != nil {
-		fmt.Println("No image uploaded on register")
-		return
-	}
-	defer file.Close()
-	// Define the relative path to the images directory
-	imagePath := filepath.Join(".", "pkg", "db", "images", regData.Username+".jpg")
-	out, err := os.Create(imagePath)
-	if err != nil {
-		http.Error(w, "Error creating file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, file)
-	if err != nil {
-		http.Error(w, "Error saving file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 4. Get the path of the saved image
-	// 5. Replace the regData.AvatarURL with the path of the saved image
-	// TODO: Change this to the actual server URL
-	regData.AvatarURL = "http://localhost:8080/images/" + regData.Username + ".jpg"
-
+// HashToken returns the hex encoded SHA-256 hash of a secret token.
+// Tokens are random, so a fast unsalted hash is enough to keep them useless if the database leaks.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func GetSessionToken(r *http.Request) string {
@@ -62,3 +36,18 @@ func GetSessionToken(r *http.Request) string {
 	}
 	return cookie.Value
 }
+
+// GetClientIP returns the IP address of the client that made the request.
+// X-Forwarded-For is only trusted when the request comes from a local reverse proxy (Caddy).
+func GetClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return host
+}
@@ -4,6 +4,7 @@ import React from "react";
 import { Formik, Field, Form, FormikHelpers, FieldProps } from "formik";
 import "../../../styles/styles.css";
 import { useRouter} from 'next/navigation';
+import { getCSRFToken } from "@/util/utils";
 
 interface LoginValues {
 	username: string;
@@ -33,7 +34,8 @@ const handleLogin = (
 	fetch('http://localhost:8080/api/users/login', {
 	  method: 'POST',
 	  headers: {
-		'Content-Type': 'application/json'
+		'Content-Type': 'application/json',
+		'X-CSRF-Token': getCSRFToken()
 	  },
 	  body: JSON.stringify(values),
 	  credentials: 'include' // Send cookies with the request
@@ -121,6 +123,9 @@ const handleRegister = (
 	// TODO: change localhost to iriesphere url
 	fetch('http://localhost:8080/api/users/register', {
 	  method: 'POST',
+	  headers: {
+		'X-CSRF-Token': getCSRFToken()
+	  },
 	  body: formData,
 	  credentials: 'include' // Send cookies with the request
 	})
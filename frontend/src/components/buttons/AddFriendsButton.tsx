@@ -1,5 +1,6 @@
 import React, { useEffect, useState } from 'react';
 import UserTab from "@/components/friends/UserTab";
+import { getCSRFToken } from "@/util/utils";
 
 interface User {
     id: string;
@@ -54,6 +55,7 @@ const AddFriendsButton: React.FC = () => {
             credentials: 'include',
             headers: {
                 'Content-Type': 'application/json',
+                'X-CSRF-Token': getCSRFToken(),
             },
         })
             .then(response => {
@@ -79,6 +81,7 @@ const AddFriendsButton: React.FC = () => {
             credentials: 'include',
             headers: {
                 'Content-Type': 'application/json',
+                'X-CSRF-Token': getCSRFToken(),
             },
         })
             .then(response => {
@@ -4,6 +4,7 @@ import {useRouter} from 'next/navigation';
 import FriendsList from '../friends/FriendsList';
 import {useEffect, useState} from "react";
 import AddFriendsButton from "@/components/buttons/AddFriendsButton";
+import { getCSRFToken } from "@/util/utils";
 
 
 //TODO: Clicking on profile icon should close it. (https://github.com/saadeghi/daisyui/issues/157) maybe too much work for now
@@ -50,6 +51,9 @@ function ProfileIconDM() {
         const response = await fetch('http://localhost:8080/api/users/logout', {
             method: 'POST',
             credentials: 'include',
+            headers: {
+                'X-CSRF-Token': getCSRFToken(),
+            },
         });
 
         if (response.ok) {
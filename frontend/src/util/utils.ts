@@ -9,3 +9,10 @@ export async function useAuth() {
       return { isAuthenticated: false }; // Return a default value in case of error
   }
 }
+
+// Returns the CSRF token the backend sets in the csrf_token cookie.
+// It has to be sent back in the X-CSRF-Token header of every POST, PUT, PATCH and DELETE request.
+export function getCSRFToken(): string {
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : '';
+}